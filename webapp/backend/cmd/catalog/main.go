@@ -0,0 +1,97 @@
+// 商品カタログの一括インポート・エクスポートを行うCLI
+//
+//	go run ./cmd/catalog import -file products.csv [-format csv|json] [-dry-run]
+//	go run ./cmd/catalog export [-format csv|json] [-file products.csv]
+//
+// 接続先は DATABASE_URL で指定する
+package main
+
+import (
+	"backend/internal/db"
+	"backend/internal/repository"
+	"backend/internal/service"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cmd := os.Args[1]
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	file := fs.String("file", "", "input/output file (default: stdin/stdout)")
+	format := fs.String("format", "", "csv or json (default: file extension, or csv)")
+	dryRun := fs.Bool("dry-run", false, "show the diff without writing to the database")
+	fs.Parse(os.Args[2:])
+
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*file)), ".")
+		if *format == "" {
+			*format = "csv"
+		}
+	}
+
+	dbConn, err := db.InitDBConnection()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer dbConn.Close()
+
+	// 別プロセスのため、起動中のバックエンドの商品一覧キャッシュは TTL 経過後に反映される
+	catalogSvc := service.NewCatalogService(repository.NewStore(dbConn), nil)
+	ctx := context.Background()
+
+	switch cmd {
+	case "import":
+		var r io.Reader = os.Stdin
+		if *file != "" {
+			f, err := os.Open(*file)
+			if err != nil {
+				log.Fatalf("Failed to open %s: %v", *file, err)
+			}
+			defer f.Close()
+			r = f
+		}
+
+		report, err := catalogSvc.Import(ctx, r, *format, *dryRun)
+		if err != nil {
+			log.Fatalf("Failed to import product catalog: %v", err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		if len(report.Errors) > 0 {
+			os.Exit(1)
+		}
+	case "export":
+		var w io.Writer = os.Stdout
+		if *file != "" {
+			f, err := os.Create(*file)
+			if err != nil {
+				log.Fatalf("Failed to create %s: %v", *file, err)
+			}
+			defer f.Close()
+			w = f
+		}
+
+		if err := catalogSvc.Export(ctx, w, *format); err != nil {
+			log.Fatalf("Failed to export product catalog: %v", err)
+		}
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: catalog import|export [-file path] [-format csv|json] [-dry-run]")
+	os.Exit(2)
+}
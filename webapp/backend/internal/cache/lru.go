@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// 上限とTTLを持つLRUキャッシュ
+// 上限を超えた場合は最も長く参照されていないものから捨てる
+type LRU[K comparable, V any] struct {
+	// エントリのコストの合計の上限。コストを指定しない場合は件数の上限になる
+	maxCost int64
+	cost    func(V) int64
+	ttl     time.Duration
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[K]*list.Element
+	total int64
+}
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+	cost  int64
+	// ゼロ値の場合は期限切れにしない
+	expiresAt time.Time
+}
+
+// 件数の上限が maxEntries のキャッシュ
+// ttl が 0 以下の場合、Set で保存した値は期限切れにならない
+func NewLRU[K comparable, V any](maxEntries int, ttl time.Duration) *LRU[K, V] {
+	return NewLRUWithCost[K, V](int64(maxEntries), ttl, nil)
+}
+
+// cost で求めた値のコスト(バイト数など)の合計が maxCost を超えないキャッシュ
+// maxCost を超える値は保存しない
+func NewLRUWithCost[K comparable, V any](maxCost int64, ttl time.Duration, cost func(V) int64) *LRU[K, V] {
+	if cost == nil {
+		cost = func(V) int64 { return 1 }
+	}
+	return &LRU[K, V]{
+		maxCost: maxCost,
+		cost:    cost,
+		ttl:     ttl,
+		ll:      list.New(),
+		items:   make(map[K]*list.Element),
+	}
+}
+
+// 有効期限内の値を返す
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	el, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	e := el.Value.(*entry[K, V])
+	if !e.expiresAt.IsZero() && !time.Now().Before(e.expiresAt) {
+		c.removeElement(el)
+		return zero, false
+	}
+	c.ll.MoveToFront(el)
+	return e.value, true
+}
+
+// デフォルトのTTLで値を保存する
+func (c *LRU[K, V]) Set(key K, value V) {
+	if c.ttl <= 0 {
+		c.set(key, value, time.Time{})
+		return
+	}
+	c.SetWithTTL(key, value, c.ttl)
+}
+
+// ttl が 0 以下の場合は保存しない
+func (c *LRU[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.set(key, value, time.Now().Add(ttl))
+}
+
+func (c *LRU[K, V]) set(key K, value V, expiresAt time.Time) {
+	cost := c.cost(value)
+	if c.maxCost <= 0 || cost > c.maxCost {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		c.total += cost - e.cost
+		e.value = value
+		e.cost = cost
+		e.expiresAt = expiresAt
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(&entry[K, V]{key: key, value: value, cost: cost, expiresAt: expiresAt})
+		c.total += cost
+	}
+	for c.total > c.maxCost {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *LRU[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// 条件に一致するエントリをすべて削除する
+func (c *LRU[K, V]) DeleteFunc(fn func(key K, value V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		e := el.Value.(*entry[K, V])
+		if fn(e.key, e.value) {
+			c.removeElement(el)
+		}
+		el = next
+	}
+}
+
+func (c *LRU[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[K]*list.Element)
+	c.total = 0
+}
+
+func (c *LRU[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *LRU[K, V]) removeElement(el *list.Element) {
+	e := el.Value.(*entry[K, V])
+	c.ll.Remove(el)
+	delete(c.items, e.key)
+	c.total -= e.cost
+}
@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	type op struct {
+		kind  string // set, setTTL, get, delete, sleep
+		key   string
+		value int
+		ttl   time.Duration
+		want  bool // get: 見つかるか
+	}
+	tests := []struct {
+		name       string
+		maxEntries int
+		ttl        time.Duration
+		ops        []op
+		wantLen    int
+	}{
+		{
+			name:       "get after set",
+			maxEntries: 2,
+			ttl:        time.Minute,
+			ops: []op{
+				{kind: "set", key: "a", value: 1},
+				{kind: "get", key: "a", value: 1, want: true},
+				{kind: "get", key: "b", want: false},
+			},
+			wantLen: 1,
+		},
+		{
+			name:       "evicts least recently used",
+			maxEntries: 2,
+			ttl:        time.Minute,
+			ops: []op{
+				{kind: "set", key: "a", value: 1},
+				{kind: "set", key: "b", value: 2},
+				{kind: "get", key: "a", value: 1, want: true},
+				{kind: "set", key: "c", value: 3},
+				{kind: "get", key: "b", want: false},
+				{kind: "get", key: "a", value: 1, want: true},
+				{kind: "get", key: "c", value: 3, want: true},
+			},
+			wantLen: 2,
+		},
+		{
+			name:       "overwrite keeps one entry",
+			maxEntries: 2,
+			ttl:        time.Minute,
+			ops: []op{
+				{kind: "set", key: "a", value: 1},
+				{kind: "set", key: "a", value: 2},
+				{kind: "get", key: "a", value: 2, want: true},
+			},
+			wantLen: 1,
+		},
+		{
+			name:       "expires after ttl",
+			maxEntries: 2,
+			ttl:        time.Minute,
+			ops: []op{
+				{kind: "setTTL", key: "a", value: 1, ttl: 10 * time.Millisecond},
+				{kind: "sleep", ttl: 20 * time.Millisecond},
+				{kind: "get", key: "a", want: false},
+			},
+			wantLen: 0,
+		},
+		{
+			name:       "non-positive ttl is not stored",
+			maxEntries: 2,
+			ttl:        time.Minute,
+			ops: []op{
+				{kind: "setTTL", key: "a", value: 1, ttl: 0},
+				{kind: "get", key: "a", want: false},
+			},
+			wantLen: 0,
+		},
+		{
+			name:       "zero default ttl never expires",
+			maxEntries: 2,
+			ttl:        0,
+			ops: []op{
+				{kind: "set", key: "a", value: 1},
+				{kind: "sleep", ttl: 10 * time.Millisecond},
+				{kind: "get", key: "a", value: 1, want: true},
+			},
+			wantLen: 1,
+		},
+		{
+			name:       "delete",
+			maxEntries: 2,
+			ttl:        time.Minute,
+			ops: []op{
+				{kind: "set", key: "a", value: 1},
+				{kind: "delete", key: "a"},
+				{kind: "get", key: "a", want: false},
+			},
+			wantLen: 0,
+		},
+		{
+			name:       "disabled when max entries is zero",
+			maxEntries: 0,
+			ttl:        time.Minute,
+			ops: []op{
+				{kind: "set", key: "a", value: 1},
+				{kind: "get", key: "a", want: false},
+			},
+			wantLen: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewLRU[string, int](tt.maxEntries, tt.ttl)
+			for i, o := range tt.ops {
+				switch o.kind {
+				case "set":
+					c.Set(o.key, o.value)
+				case "setTTL":
+					c.SetWithTTL(o.key, o.value, o.ttl)
+				case "delete":
+					c.Delete(o.key)
+				case "sleep":
+					time.Sleep(o.ttl)
+				case "get":
+					v, ok := c.Get(o.key)
+					if ok != o.want || (ok && v != o.value) {
+						t.Errorf("op %d: Get(%q) = %d, %t, want %d, %t", i, o.key, v, ok, o.value, o.want)
+					}
+				}
+			}
+			if got := c.Len(); got != tt.wantLen {
+				t.Errorf("Len() = %d, want %d", got, tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestLRUWithCost(t *testing.T) {
+	c := NewLRUWithCost[string](10, 0, func(v []byte) int64 { return int64(len(v)) })
+
+	c.Set("a", make([]byte, 4))
+	c.Set("b", make([]byte, 4))
+	// 合計が上限を超えるため、最も古い a が捨てられる
+	c.Set("c", make([]byte, 4))
+	if _, ok := c.Get("a"); ok {
+		t.Error("a should have been evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+
+	// 上限を超える値は保存しない
+	c.Set("huge", make([]byte, 11))
+	if _, ok := c.Get("huge"); ok {
+		t.Error("value larger than max cost should not be stored")
+	}
+
+	// 上書きで小さくなった分は空き容量に戻る
+	c.Set("b", make([]byte, 1))
+	c.Set("d", make([]byte, 5))
+	for _, key := range []string{"b", "c", "d"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("%s should be cached", key)
+		}
+	}
+
+	c.DeleteFunc(func(key string, v []byte) bool { return len(v) > 4 })
+	if _, ok := c.Get("d"); ok {
+		t.Error("d should have been deleted")
+	}
+	c.Purge()
+	if c.Len() != 0 {
+		t.Errorf("Len() after Purge = %d, want 0", c.Len())
+	}
+	c.Set("e", make([]byte, 10))
+	if _, ok := c.Get("e"); !ok {
+		t.Error("cost should be reset by Purge")
+	}
+}
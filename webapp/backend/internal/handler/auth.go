@@ -4,18 +4,25 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 
+	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/service"
+
+	"github.com/go-chi/chi/v5"
 )
 
 type AuthHandler struct {
-	AuthSvc *service.AuthService
+	AuthSvc         *service.AuthService
+	SessionPurgeSvc *service.SessionPurgeService
+	SessionCookie   middleware.SessionCookie
 }
 
-func NewAuthHandler(authSvc *service.AuthService) *AuthHandler {
-	return &AuthHandler{AuthSvc: authSvc}
+func NewAuthHandler(authSvc *service.AuthService, sessionPurgeSvc *service.SessionPurgeService, sessionCookie middleware.SessionCookie) *AuthHandler {
+	return &AuthHandler{AuthSvc: authSvc, SessionPurgeSvc: sessionPurgeSvc, SessionCookie: sessionCookie}
 }
 
 // ログイン時にセッションを発行し、Cookieにセットする
@@ -28,7 +35,7 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sessionID, expiresAt, err := h.AuthSvc.Login(r.Context(), req.UserName, req.Password)
+	sessionID, expiresAt, err := h.AuthSvc.Login(r.Context(), req.UserName, req.Password, r.UserAgent(), clientIP(r))
 	if err != nil {
 		if errors.Is(err, service.ErrUserNotFound) || errors.Is(err, service.ErrInvalidPassword) {
 			http.Error(w, "Unauthorized: Invalid credentials", http.StatusUnauthorized)
@@ -38,15 +45,126 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    sessionID,
-		Expires:  expiresAt,
-		HttpOnly: true,
-		Path:     "/",
-	})
+	h.SessionCookie.Set(w, sessionID, expiresAt)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Login successful"})
 }
+
+// セッションを削除し、Cookieを消去する
+// セッションが無効・期限切れでも成功として扱う
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(middleware.SessionCookieName); err == nil && cookie.Value != "" {
+		if err := h.AuthSvc.Logout(r.Context(), cookie.Value); err != nil {
+			log.Printf("Failed to delete session: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	h.SessionCookie.Clear(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logout successful"})
+}
+
+// ログイン中のセッション一覧を取得
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+	currentSessionID, _ := middleware.GetSessionFromContext(r.Context())
+
+	sessions, err := h.AuthSvc.ListSessions(r.Context(), userID, currentSessionID)
+	if err != nil {
+		log.Printf("Failed to fetch sessions for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch sessions", http.StatusInternalServerError)
+		return
+	}
+	if sessions == nil {
+		sessions = []model.Session{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": sessions})
+}
+
+// セッションを1件失効させる
+// リクエストに使われたセッションを失効させた場合はCookieも消去する
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid session id", http.StatusBadRequest)
+		return
+	}
+
+	sessionID, err := h.AuthSvc.RevokeSession(r.Context(), userID, id)
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to revoke session %d for user %d: %v", id, userID, err)
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+	if currentSessionID, _ := middleware.GetSessionFromContext(r.Context()); sessionID == currentSessionID {
+		h.SessionCookie.Clear(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 全セッションを失効させる
+// ?except_current=true の場合はリクエストに使われたセッションを残す
+func (h *AuthHandler) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+	exceptCurrent, _ := strconv.ParseBool(r.URL.Query().Get("except_current"))
+	var exceptSessionID string
+	if exceptCurrent {
+		exceptSessionID, _ = middleware.GetSessionFromContext(r.Context())
+	}
+
+	revoked, err := h.AuthSvc.RevokeAllSessions(r.Context(), userID, exceptSessionID)
+	if err != nil {
+		log.Printf("Failed to revoke sessions for user %d: %v", userID, err)
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+	if !exceptCurrent {
+		h.SessionCookie.Clear(w)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"revoked": revoked})
+}
+
+// 期限切れセッション削除ジョブの実行回数や削除件数を返す
+func (h *AuthHandler) SessionPurgeStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.SessionPurgeSvc.Stats())
+}
+
+// クライアントのIPアドレス
+// nginx 経由では X-Real-IP にセットされる
+func clientIP(r *http.Request) string {
+	if ip := net.ParseIP(r.Header.Get("X-Real-IP")); ip != nil {
+		return ip.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"backend/internal/service"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// インポートで受け付けるリクエストボディの上限
+const maxCatalogImportBytes = 64 << 20
+
+type CatalogHandler struct {
+	CatalogSvc *service.CatalogService
+	ProductSvc *service.ProductService
+}
+
+func NewCatalogHandler(svc *service.CatalogService, productSvc *service.ProductService) *CatalogHandler {
+	return &CatalogHandler{CatalogSvc: svc, ProductSvc: productSvc}
+}
+
+// 商品カタログを一括で登録・更新する
+// ?format=csv|json (省略時は Content-Type から判定), ?dry_run=true で差分のみ返す
+func (h *CatalogHandler) Import(w http.ResponseWriter, r *http.Request) {
+	format := catalogFormat(r.URL.Query().Get("format"), r.Header.Get("Content-Type"))
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	body := http.MaxBytesReader(w, r.Body, maxCatalogImportBytes)
+	report, err := h.CatalogSvc.Import(r.Context(), body, format, dryRun)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.Is(err, service.ErrUnsupportedCatalogFormat):
+			http.Error(w, "Query parameter 'format' must be csv or json", http.StatusBadRequest)
+		case errors.As(err, &maxBytesErr):
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		case errors.Is(err, service.ErrInvalidCatalog):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			log.Printf("Failed to import product catalog: %v", err)
+			http.Error(w, "Failed to import product catalog", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if len(report.Errors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// 全商品を CSV または JSON でストリーミング出力する
+func (h *CatalogHandler) Export(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	case "json":
+		contentType = "application/json"
+	default:
+		http.Error(w, "Query parameter 'format' must be csv or json", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="products.`+format+`"`)
+	if err := h.CatalogSvc.Export(r.Context(), w, format); err != nil {
+		// ヘッダー送信後なのでステータスは変更できない
+		log.Printf("Failed to export product catalog: %v", err)
+	}
+}
+
+// 商品一覧キャッシュのヒット率などを返す
+func (h *CatalogHandler) CacheStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.ProductSvc.CacheStats())
+}
+
+func catalogFormat(format, contentType string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+	switch {
+	case strings.HasPrefix(contentType, "text/csv"):
+		return "csv"
+	case strings.HasPrefix(contentType, "application/json"):
+		return "json"
+	}
+	return ""
+}
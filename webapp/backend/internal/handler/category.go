@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"backend/internal/model"
+	"backend/internal/service"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+type CategoryHandler struct {
+	CategorySvc *service.CategoryService
+}
+
+func NewCategoryHandler(svc *service.CategoryService) *CategoryHandler {
+	return &CategoryHandler{CategorySvc: svc}
+}
+
+// カテゴリを階層構造で取得
+func (h *CategoryHandler) Tree(w http.ResponseWriter, r *http.Request) {
+	tree, err := h.CategorySvc.GetTree(r.Context())
+	if err != nil {
+		log.Printf("Failed to fetch category tree: %v", err)
+		http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
+		return
+	}
+
+	resp := struct {
+		Data []*model.Category `json:"data"`
+	}{
+		Data: tree,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// タグ一覧を取得
+func (h *CategoryHandler) Tags(w http.ResponseWriter, r *http.Request) {
+	tags, err := h.CategorySvc.ListTags(r.Context())
+	if err != nil {
+		log.Printf("Failed to fetch tags: %v", err)
+		http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+		return
+	}
+	if tags == nil {
+		tags = []model.Tag{}
+	}
+
+	resp := struct {
+		Data []model.Tag `json:"data"`
+	}{
+		Data: tags,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
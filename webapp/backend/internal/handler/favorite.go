@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"backend/internal/middleware"
+	"backend/internal/model"
+	"backend/internal/service"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type FavoriteHandler struct {
+	FavoriteSvc *service.FavoriteService
+}
+
+func NewFavoriteHandler(svc *service.FavoriteService) *FavoriteHandler {
+	return &FavoriteHandler{FavoriteSvc: svc}
+}
+
+// お気に入りに追加
+func (h *FavoriteHandler) Add(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+	productID, err := strconv.Atoi(chi.URLParam(r, "product_id"))
+	if err != nil || productID <= 0 {
+		http.Error(w, "Invalid product_id", http.StatusBadRequest)
+		return
+	}
+
+	created, err := h.FavoriteSvc.Add(r.Context(), userID, productID)
+	if err != nil {
+		if errors.Is(err, service.ErrProductNotFound) {
+			http.Error(w, "Product not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to add favorite for user %d: %v", userID, err)
+		http.Error(w, "Failed to add favorite", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"product_id":  productID,
+		"is_favorite": true,
+	})
+}
+
+// お気に入りから削除
+func (h *FavoriteHandler) Remove(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+	productID, err := strconv.Atoi(chi.URLParam(r, "product_id"))
+	if err != nil || productID <= 0 {
+		http.Error(w, "Invalid product_id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.FavoriteSvc.Remove(r.Context(), userID, productID); err != nil {
+		log.Printf("Failed to remove favorite for user %d: %v", userID, err)
+		http.Error(w, "Failed to remove favorite", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// お気に入り一覧を取得
+// ?page=1&page_size=20
+func (h *FavoriteHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+	if pageSize > 200 {
+		pageSize = 200
+	}
+
+	products, total, err := h.FavoriteSvc.List(r.Context(), userID, page, pageSize)
+	if err != nil {
+		log.Printf("Failed to fetch favorites for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch favorites", http.StatusInternalServerError)
+		return
+	}
+	if products == nil {
+		products = []model.Product{}
+	}
+
+	resp := struct {
+		Data  []model.Product `json:"data"`
+		Total int             `json:"total"`
+	}{
+		Data:  products,
+		Total: total,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
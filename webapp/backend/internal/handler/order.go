@@ -5,16 +5,40 @@ import (
 	"backend/internal/model"
 	"backend/internal/service"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// 絞り込みに指定できる注文ステータス
+var orderStatuses = map[string]bool{
+	"scheduled":  true,
+	"shipping":   true,
+	"delivering": true,
+	"completed":  true,
+	"cancelled":  true,
+}
+
+const (
+	// 期間を指定しない場合に集計する日数
+	defaultOrderStatsDays = 30
+	// 日ごとに集計できる最大の日数
+	maxOrderStatsDailyDays = 366
 )
 
 type OrderHandler struct {
 	OrderSvc *service.OrderService
+	QuotaSvc *service.QuotaService
 }
 
-func NewOrderHandler(svc *service.OrderService) *OrderHandler {
-	return &OrderHandler{OrderSvc: svc}
+func NewOrderHandler(svc *service.OrderService, quotaSvc *service.QuotaService) *OrderHandler {
+	return &OrderHandler{OrderSvc: svc, QuotaSvc: quotaSvc}
 }
 
 // 注文履歴一覧を取得
@@ -48,6 +72,23 @@ func (h *OrderHandler) List(w http.ResponseWriter, r *http.Request) {
 		req.Type = "partial"
 	}
 
+	filter, err := newOrderFilter(req.Statuses, req.CreatedFrom, req.CreatedTo, req.ArrivedFrom, req.ArrivedTo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Filter = filter
+
+	switch req.GroupBy {
+	case "":
+	case "checkout":
+		h.listCheckouts(w, r, userID, req)
+		return
+	default:
+		http.Error(w, "group_by must be empty or 'checkout'", http.StatusBadRequest)
+		return
+	}
+
 	orders, total, err := h.OrderSvc.FetchOrders(r.Context(), userID, req)
 	if err != nil {
 		log.Printf("Failed to fetch orders for user %d: %v", userID, err)
@@ -66,3 +107,280 @@ func (h *OrderHandler) List(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+// 注文履歴を注文ヘッダー単位で返す
+func (h *OrderHandler) listCheckouts(w http.ResponseWriter, r *http.Request, userID int, req model.ListRequest) {
+	checkouts, total, err := h.OrderSvc.FetchCheckouts(r.Context(), userID, req)
+	if err != nil {
+		log.Printf("Failed to fetch checkouts for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch orders", http.StatusInternalServerError)
+		return
+	}
+	if checkouts == nil {
+		checkouts = []model.Checkout{}
+	}
+
+	resp := struct {
+		Data  []model.Checkout `json:"data"`
+		Total int              `json:"total"`
+	}{
+		Data:  checkouts,
+		Total: total,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 注文の詳細を取得
+func (h *OrderHandler) Get(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || orderID <= 0 {
+		http.Error(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	detail, err := h.OrderSvc.GetOrderDetail(r.Context(), userID, orderID)
+	if err != nil {
+		if errors.Is(err, service.ErrOrderNotFound) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to fetch order %d for user %d: %v", orderID, userID, err)
+		http.Error(w, "Failed to fetch order", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
+// 注文をキャンセル
+func (h *OrderHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || orderID <= 0 {
+		http.Error(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.OrderSvc.CancelOrder(r.Context(), userID, orderID); err != nil {
+		switch {
+		case errors.Is(err, service.ErrOrderNotFound):
+			http.Error(w, "Order not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrOrderNotCancellable):
+			http.Error(w, "Order has already been picked up by a robot and cannot be cancelled", http.StatusConflict)
+		default:
+			log.Printf("Failed to cancel order %d for user %d: %v", orderID, userID, err)
+			http.Error(w, "Failed to cancel order", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"order_id":       orderID,
+		"shipped_status": "cancelled",
+	})
+}
+
+// 注文履歴の絞り込み条件を検証する
+// 返すエラーはそのままクライアントに返せるメッセージにする
+func newOrderFilter(statuses []string, createdFrom, createdTo, arrivedFrom, arrivedTo string) (model.OrderFilter, error) {
+	var f model.OrderFilter
+	seen := make(map[string]bool, len(statuses))
+	for _, status := range statuses {
+		status = strings.TrimSpace(status)
+		if status == "" {
+			continue
+		}
+		if !orderStatuses[status] {
+			return f, fmt.Errorf("unknown status %q", status)
+		}
+		if !seen[status] {
+			seen[status] = true
+			f.Statuses = append(f.Statuses, status)
+		}
+	}
+
+	var err error
+	if f.CreatedFrom, err = parseOrderTime("created_from", createdFrom, false); err != nil {
+		return f, err
+	}
+	if f.CreatedTo, err = parseOrderTime("created_to", createdTo, true); err != nil {
+		return f, err
+	}
+	if f.ArrivedFrom, err = parseOrderTime("arrived_from", arrivedFrom, false); err != nil {
+		return f, err
+	}
+	if f.ArrivedTo, err = parseOrderTime("arrived_to", arrivedTo, true); err != nil {
+		return f, err
+	}
+	if f.CreatedFrom != nil && f.CreatedTo != nil && !f.CreatedFrom.Before(*f.CreatedTo) {
+		return f, fmt.Errorf("created_from must be before created_to")
+	}
+	if f.ArrivedFrom != nil && f.ArrivedTo != nil && !f.ArrivedFrom.Before(*f.ArrivedTo) {
+		return f, fmt.Errorf("arrived_from must be before arrived_to")
+	}
+	return f, nil
+}
+
+// RFC3339 または "2006-01-02" 形式の日時をパースする
+// 日付のみで範囲の終わりに指定された場合は、その日を含むよう翌日の0時にする
+func parseOrderTime(name, value string, end bool) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be RFC3339 or YYYY-MM-DD", name)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// 注文履歴を CSV または JSON で出力
+// 絞り込み条件は一覧と同じものをクエリパラメータで指定する
+func (h *OrderHandler) Export(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	case "json":
+		contentType = "application/json"
+	default:
+		http.Error(w, "Query parameter 'format' must be csv or json", http.StatusBadRequest)
+		return
+	}
+
+	req := model.ListRequest{
+		Search:    q.Get("search"),
+		Type:      q.Get("type"),
+		SortField: q.Get("sort_field"),
+		SortOrder: q.Get("sort_order"),
+	}
+	if req.SortField == "" {
+		req.SortField = "order_id"
+	}
+	// statuses=a&statuses=b、statuses[]=a、statuses=a,b のいずれの形式も受け付ける
+	var statuses []string
+	for _, v := range append(q["statuses"], q["statuses[]"]...) {
+		statuses = append(statuses, strings.Split(v, ",")...)
+	}
+	filter, err := newOrderFilter(statuses, q.Get("created_from"), q.Get("created_to"), q.Get("arrived_from"), q.Get("arrived_to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Filter = filter
+
+	filename := fmt.Sprintf("orders-%s.%s", time.Now().Format("20060102"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	if err := h.OrderSvc.ExportOrders(r.Context(), w, userID, req, format); err != nil {
+		// ヘッダー送信後なのでステータスは変更できない
+		log.Printf("Failed to export orders for user %d: %v", userID, err)
+	}
+}
+
+// 注文数の上限と現在の利用状況を取得
+func (h *OrderHandler) Quota(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	quota, err := h.QuotaSvc.GetQuota(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to fetch order quota for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch order quota", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(quota)
+}
+
+// 注文の集計を取得
+// from/to は RFC3339 または "2006-01-02" 形式で、省略時は直近30日
+func (h *OrderHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	q := r.URL.Query()
+	interval := q.Get("interval")
+	if interval == "" {
+		interval = "day"
+	}
+	if interval != "day" && interval != "month" {
+		http.Error(w, "Query parameter 'interval' must be day or month", http.StatusBadRequest)
+		return
+	}
+
+	from, err := parseOrderTime("from", q.Get("from"), false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseOrderTime("to", q.Get("to"), true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if to == nil {
+		now := time.Now()
+		to = &now
+	}
+	if from == nil {
+		f := to.AddDate(0, 0, -defaultOrderStatsDays)
+		from = &f
+	}
+	if !from.Before(*to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+	if interval == "day" && to.Sub(*from) > maxOrderStatsDailyDays*24*time.Hour {
+		http.Error(w, fmt.Sprintf("Daily stats are limited to %d days. Use interval=month for longer ranges", maxOrderStatsDailyDays), http.StatusBadRequest)
+		return
+	}
+
+	stats, err := h.OrderSvc.FetchStats(r.Context(), userID, *from, *to, interval)
+	if err != nil {
+		log.Printf("Failed to fetch order stats for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch order stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseOrderTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		end     bool
+		want    *time.Time
+		wantErr bool
+	}{
+		{name: "empty", value: "  ", want: nil},
+		{name: "rfc3339", value: "2024-05-01T10:00:00+09:00", want: ptrTime(time.Date(2024, 5, 1, 1, 0, 0, 0, time.UTC))},
+		{name: "rfc3339 as end", value: "2024-05-01T10:00:00Z", end: true, want: ptrTime(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))},
+		{name: "date", value: "2024-05-01", want: ptrTime(time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local))},
+		{name: "date as end includes the day", value: "2024-05-01", end: true, want: ptrTime(time.Date(2024, 5, 2, 0, 0, 0, 0, time.Local))},
+		{name: "invalid", value: "2024/05/01", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOrderTime("created_from", tt.value, tt.end)
+			if tt.wantErr {
+				if err == nil || err.Error() != "created_from must be RFC3339 or YYYY-MM-DD" {
+					t.Errorf("parseOrderTime(%q) error = %v", tt.value, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && !got.Equal(*tt.want)) {
+				t.Errorf("parseOrderTime(%q, %t) = %v, want %v", tt.value, tt.end, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewOrderFilter(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []string
+		createdFrom  string
+		createdTo    string
+		arrivedFrom  string
+		arrivedTo    string
+		wantStatuses []string
+		wantErr      string
+	}{
+		{name: "no conditions"},
+		{
+			name:         "statuses are trimmed and deduplicated",
+			statuses:     []string{" shipping", "", "completed", "shipping "},
+			wantStatuses: []string{"shipping", "completed"},
+		},
+		{name: "unknown status", statuses: []string{"complete"}, wantErr: `unknown status "complete"`},
+		{name: "same day range", createdFrom: "2024-05-01", createdTo: "2024-05-01"},
+		{name: "reversed created range", createdFrom: "2024-05-02", createdTo: "2024-05-01", wantErr: "created_from must be before created_to"},
+		{
+			name:        "empty created range",
+			createdFrom: "2024-05-01T00:00:00Z",
+			createdTo:   "2024-05-01T00:00:00Z",
+			wantErr:     "created_from must be before created_to",
+		},
+		{name: "reversed arrived range", arrivedFrom: "2024-05-02", arrivedTo: "2024-05-01", wantErr: "arrived_from must be before arrived_to"},
+		{name: "invalid arrived_to", arrivedTo: "yesterday", wantErr: "arrived_to must be RFC3339 or YYYY-MM-DD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newOrderFilter(tt.statuses, tt.createdFrom, tt.createdTo, tt.arrivedFrom, tt.arrivedTo)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(f.Statuses, tt.wantStatuses) {
+				t.Errorf("Statuses = %q, want %q", f.Statuses, tt.wantStatuses)
+			}
+			if (f.CreatedFrom != nil) != (tt.createdFrom != "") || (f.CreatedTo != nil) != (tt.createdTo != "") {
+				t.Errorf("created range = %v - %v", f.CreatedFrom, f.CreatedTo)
+			}
+		})
+	}
+}
+
+func ptrTime(t time.Time) *time.Time { return &t }
@@ -4,21 +4,32 @@ import (
 	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/service"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
+
+	"github.com/go-chi/chi/v5"
 )
 
+// 画像はログインが必要な API で返すため、CDN やプロキシなどの共有キャッシュには載せない
+const imageCacheControl = "private, max-age=86400"
+
+// idempotency_keys.idempotency_key の長さ
+const maxIdempotencyKeyLength = 255
+
 type ProductHandler struct {
 	ProductSvc *service.ProductService
+	ImageSvc   *service.ImageService
 }
 
-func NewProductHandler(svc *service.ProductService) *ProductHandler {
-	return &ProductHandler{ProductSvc: svc}
+func NewProductHandler(svc *service.ProductService, imageSvc *service.ImageService) *ProductHandler {
+	return &ProductHandler{ProductSvc: svc, ImageSvc: imageSvc}
 }
 
 // 商品一覧を取得
@@ -47,6 +58,11 @@ func (h *ProductHandler) List(w http.ResponseWriter, r *http.Request) {
 	if req.SortOrder == "" {
 		req.SortOrder = "asc"
 	}
+	if req.CategoryID < 0 {
+		http.Error(w, "category_id must not be negative", http.StatusBadRequest)
+		return
+	}
+	req.Tag = strings.TrimSpace(req.Tag)
 	req.Offset = (req.Page - 1) * req.PageSize
 
 	products, total, err := h.ProductSvc.FetchProducts(r.Context(), userID, req)
@@ -75,6 +91,10 @@ func (h *ProductHandler) CreateOrders(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "User not found in context", http.StatusInternalServerError)
 		return
 	}
+	idempotencyKey, ok := idempotencyKeyFromRequest(w, r)
+	if !ok {
+		return
+	}
 
 	var req model.CreateOrderRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -82,69 +102,221 @@ func (h *ProductHandler) CreateOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	insertedOrderIDs, err := h.ProductSvc.CreateOrders(r.Context(), userID, req.Items)
+	checkout, replayed, err := h.ProductSvc.CreateOrders(r.Context(), userID, req, idempotencyKey)
+	if err != nil {
+		writeCreateOrdersError(w, err)
+		return
+	}
+	writeCreatedOrders(w, checkout, replayed, nil)
+}
+
+// 過去の注文(数量1単位)と同じ商品を注文
+func (h *ProductHandler) ReorderOrder(w http.ResponseWriter, r *http.Request) {
+	h.reorder(w, r, h.ProductSvc.ReorderOrder)
+}
+
+// 過去の注文ヘッダーと同じ商品・数量で注文
+func (h *ProductHandler) ReorderCheckout(w http.ResponseWriter, r *http.Request) {
+	h.reorder(w, r, h.ProductSvc.ReorderCheckout)
+}
+
+func (h *ProductHandler) reorder(w http.ResponseWriter, r *http.Request, reorder func(ctx context.Context, userID int, id int64, idempotencyKey string) (*model.ReorderResult, error)) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	idempotencyKey, ok := idempotencyKeyFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := reorder(r.Context(), userID, id, idempotencyKey)
 	if err != nil {
-		log.Printf("Failed to create orders: %v", err)
-		http.Error(w, "Failed to process order request", http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, service.ErrOrderNotFound):
+			http.Error(w, "Order not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrCheckoutNotFound):
+			http.Error(w, "Checkout not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrNothingToReorder):
+			http.Error(w, "None of the products in the original order are available", http.StatusUnprocessableEntity)
+		default:
+			writeCreateOrdersError(w, err)
+		}
+		return
+	}
+	writeCreatedOrders(w, result.Checkout, result.Replayed, result.Skipped)
+}
+
+// 再送時に二重に注文しないためのキー。省略した場合は毎回新しい注文になる
+func idempotencyKeyFromRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+		return "", false
+	}
+	return idempotencyKey, true
+}
+
+func writeCreateOrdersError(w http.ResponseWriter, err error) {
+	var validationErr *service.OrderValidationError
+	if errors.As(err, &validationErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Invalid order items",
+			"errors":  validationErr.Errors,
+		})
+		return
+	}
+	var quotaErr *service.OrderQuotaError
+	if errors.As(err, &quotaErr) {
+		writeOrderQuotaError(w, quotaErr)
 		return
 	}
+	if errors.Is(err, service.ErrEmptyOrder) {
+		http.Error(w, "Order must contain at least one item", http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, service.ErrInvalidShipAfter) {
+		http.Error(w, "ship_after must be within 365 days", http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, service.ErrIdempotencyKeyMismatch) {
+		http.Error(w, "Idempotency-Key was already used with a different request body", http.StatusUnprocessableEntity)
+		return
+	}
+	log.Printf("Failed to create orders: %v", err)
+	http.Error(w, "Failed to process order request", http.StatusInternalServerError)
+}
+
+// 作成した注文を返す。skipped は再注文で除外した商品で、通常の注文では nil
+func writeCreatedOrders(w http.ResponseWriter, checkout *model.Checkout, replayed bool, skipped []model.SkippedItem) {
+	var insertedOrderIDs []string
+	for _, item := range checkout.Items {
+		insertedOrderIDs = append(insertedOrderIDs, item.OrderIDs...)
+	}
 
 	response := map[string]interface{}{
-		"message":   "Orders created successfully",
-		"order_ids": insertedOrderIDs,
+		"message":     "Orders created successfully",
+		"checkout_id": checkout.CheckoutID,
+		"total_value": checkout.TotalValue,
+		"items":       checkout.Items,
+		"order_ids":   insertedOrderIDs,
+	}
+	if skipped != nil {
+		response["skipped"] = skipped
 	}
 	w.Header().Set("Content-Type", "application/json")
+	if replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(response)
 }
 
+// 商品画像を取得
+// w, h, format を指定するとリサイズ・再エンコードした画像を返す
 func (h *ProductHandler) GetImage(w http.ResponseWriter, r *http.Request) {
 	fmt.Printf("画像リクエスト受信: %s\n", r.URL.String())
-	imagePath := r.URL.Query().Get("path")
+	query := r.URL.Query()
+	imagePath := query.Get("path")
 	if imagePath == "" {
 		fmt.Println("画像パスが空です")
 		http.Error(w, "画像パスが指定されていません", http.StatusBadRequest)
 		return
 	}
 
-	imagePath = filepath.Clean(imagePath)
-	if filepath.IsAbs(imagePath) || strings.Contains(imagePath, "..") {
-		fmt.Printf("無効なパス: %s\n", imagePath)
-		http.Error(w, "無効なパスです", http.StatusBadRequest)
-		return
+	var opts service.ImageOptions
+	var err error
+	if v := query.Get("w"); v != "" {
+		if opts.Width, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "w は整数で指定してください", http.StatusBadRequest)
+			return
+		}
 	}
+	if v := query.Get("h"); v != "" {
+		if opts.Height, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "h は整数で指定してください", http.StatusBadRequest)
+			return
+		}
+	}
+	opts.Format = query.Get("format")
 
-	baseImageDir := "/app/images"
-	fullPath := filepath.Join(baseImageDir, imagePath)
-
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-		fmt.Printf("画像ファイルが見つかりません: %s\n", fullPath)
-		http.Error(w, "画像が見つかりません", http.StatusNotFound)
+	// 署名付きURLを発行できる保存先なら、画像本体はストレージから直接取得させる
+	signedURL, ok, err := h.ImageSvc.SignedURL(r.Context(), imagePath, opts)
+	if err == nil && ok {
+		// 署名付きURLには有効期限があるためリダイレクト自体はキャッシュさせない
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, signedURL, http.StatusFound)
 		return
 	}
 
-	ext := filepath.Ext(fullPath)
-	var contentType string
-	switch strings.ToLower(ext) {
-	case ".jpg", ".jpeg":
-		contentType = "image/jpeg"
-	case ".png":
-		contentType = "image/png"
-	case ".gif":
-		contentType = "image/gif"
-	case ".webp":
-		contentType = "image/webp"
-	default:
-		contentType = "application/octet-stream"
+	var img *service.Image
+	if err == nil {
+		img, err = h.ImageSvc.GetImage(r.Context(), imagePath, opts)
 	}
-	w.Header().Set("Content-Type", contentType)
-
-	data, err := os.ReadFile(fullPath)
 	if err != nil {
-		fmt.Printf("画像ファイルの読み込みに失敗: %s\n", fullPath)
-		http.Error(w, "画像の読み込みに失敗しました", http.StatusInternalServerError)
+		// エラー応答がブラウザやnginxにキャッシュされないようにする
+		w.Header().Set("Cache-Control", "no-store")
+		switch {
+		case errors.Is(err, service.ErrInvalidImagePath):
+			fmt.Printf("無効なパス: %s\n", imagePath)
+			http.Error(w, "無効なパスです", http.StatusBadRequest)
+		case errors.Is(err, service.ErrUnsupportedImageSize):
+			http.Error(w, "指定できない画像サイズです", http.StatusBadRequest)
+		case errors.Is(err, service.ErrUnsupportedImageFormat):
+			http.Error(w, "指定できない画像形式です", http.StatusBadRequest)
+		case errors.Is(err, service.ErrImageTooLarge):
+			fmt.Printf("画像が大きすぎます: %s: %v\n", imagePath, err)
+			http.Error(w, "画像が大きすぎるため変換できません", http.StatusUnprocessableEntity)
+		case errors.Is(err, service.ErrImageNotFound):
+			fmt.Printf("画像ファイルが見つかりません: %s\n", imagePath)
+			http.Error(w, "画像が見つかりません", http.StatusNotFound)
+		default:
+			fmt.Printf("画像ファイルの読み込みに失敗: %s: %v\n", imagePath, err)
+			http.Error(w, "画像の読み込みに失敗しました", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	w.Write(data)
+	// ETag/Last-Modified による条件付きリクエスト(304)と Range リクエストは
+	// http.ServeContent に任せる
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Header().Set("ETag", img.ETag)
+	w.Header().Set("Cache-Control", imageCacheControl)
+	http.ServeContent(w, r, "", img.ModTime, bytes.NewReader(img.Data))
+}
+
+// 1回の注文の上限超過は注文内容の誤りとして 422、期間・未配送数の上限超過は 429 を返す
+func writeOrderQuotaError(w http.ResponseWriter, err *service.OrderQuotaError) {
+	status := http.StatusTooManyRequests
+	var message string
+	switch err.Quota {
+	case service.QuotaPerRequest:
+		status = http.StatusUnprocessableEntity
+		message = fmt.Sprintf("An order may contain at most %d units", err.Limit)
+	case service.QuotaPerDay:
+		message = fmt.Sprintf("Daily order limit of %d units exceeded", err.Limit)
+	default:
+		message = fmt.Sprintf("Limit of %d units awaiting shipment exceeded", err.Limit)
+	}
+	if err.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(err.RetryAfter.Seconds())+1))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   message,
+		"quota":     err.Quota,
+		"limit":     err.Limit,
+		"used":      err.Used,
+		"requested": err.Requested,
+	})
 }
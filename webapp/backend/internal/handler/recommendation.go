@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"backend/internal/middleware"
+	"backend/internal/model"
+	"backend/internal/service"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultRecommendationLimit = 10
+	maxRecommendationLimit     = 50
+)
+
+type RecommendationHandler struct {
+	RecommendationSvc *service.RecommendationService
+}
+
+func NewRecommendationHandler(svc *service.RecommendationService) *RecommendationHandler {
+	return &RecommendationHandler{RecommendationSvc: svc}
+}
+
+// ログインユーザーがよく注文する商品を取得
+func (h *RecommendationHandler) Frequent(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	products, err := h.RecommendationSvc.FrequentlyOrdered(r.Context(), userID, recommendationLimit(r))
+	if err != nil {
+		log.Printf("Failed to fetch frequently ordered products for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch recommendations", http.StatusInternalServerError)
+		return
+	}
+	writeRecommendations(w, products)
+}
+
+// 指定した商品と一緒に注文されることの多い商品を取得
+func (h *RecommendationHandler) CoOrdered(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "product_id"))
+	if err != nil || productID <= 0 {
+		http.Error(w, "Invalid product_id", http.StatusBadRequest)
+		return
+	}
+
+	products, err := h.RecommendationSvc.CoOrdered(r.Context(), productID, recommendationLimit(r))
+	if err != nil {
+		log.Printf("Failed to fetch co-ordered products for product %d: %v", productID, err)
+		http.Error(w, "Failed to fetch recommendations", http.StatusInternalServerError)
+		return
+	}
+	writeRecommendations(w, products)
+}
+
+func recommendationLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return defaultRecommendationLimit
+	}
+	return min(limit, maxRecommendationLimit)
+}
+
+func writeRecommendations(w http.ResponseWriter, products []model.RecommendedProduct) {
+	if products == nil {
+		products = []model.RecommendedProduct{}
+	}
+	resp := struct {
+		Data []model.RecommendedProduct `json:"data"`
+	}{
+		Data: products,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
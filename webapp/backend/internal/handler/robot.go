@@ -4,6 +4,7 @@ import (
 	"backend/internal/model"
 	"backend/internal/service"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -53,6 +54,17 @@ func (h *RobotHandler) UpdateOrderStatus(w http.ResponseWriter, r *http.Request)
 
 	err := h.RobotSvc.UpdateOrderStatus(r.Context(), req.OrderID, req.NewStatus)
 	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUnknownOrderStatus):
+			http.Error(w, "Invalid new_status", http.StatusBadRequest)
+			return
+		case errors.Is(err, service.ErrOrderNotFound):
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		case errors.Is(err, service.ErrInvalidStatusTransition):
+			http.Error(w, "Order status cannot be changed to "+req.NewStatus, http.StatusConflict)
+			return
+		}
 		log.Printf("Failed to update order status for order %d: %v", req.OrderID, err)
 		http.Error(w, "Failed to update order status", http.StatusInternalServerError)
 		return
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"backend/internal/middleware"
+	"backend/internal/model"
+	"backend/internal/service"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type WebhookHandler struct {
+	WebhookSvc *service.WebhookService
+}
+
+func NewWebhookHandler(svc *service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{WebhookSvc: svc}
+}
+
+type createWebhookRequest struct {
+	URL string `json:"url"`
+}
+
+// Webhook を登録
+// レスポンスの secret は署名の確認に使う鍵で、このときのみ返す
+func (h *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	var req createWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := h.WebhookSvc.Register(r.Context(), userID, req.URL)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidWebhookURL):
+			http.Error(w, "url must be an absolute http or https URL pointing to a public address", http.StatusBadRequest)
+		case errors.Is(err, service.ErrTooManyWebhooks):
+			http.Error(w, "Too many webhooks registered", http.StatusConflict)
+		default:
+			log.Printf("Failed to register webhook for user %d: %v", userID, err)
+			http.Error(w, "Failed to register webhook", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// 登録済みの Webhook 一覧を取得
+func (h *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	webhooks, err := h.WebhookSvc.List(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to fetch webhooks for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch webhooks", http.StatusInternalServerError)
+		return
+	}
+	if webhooks == nil {
+		webhooks = []model.Webhook{}
+	}
+
+	resp := struct {
+		Data []model.Webhook `json:"data"`
+	}{
+		Data: webhooks,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Webhook を削除
+func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+	webhookID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || webhookID <= 0 {
+		http.Error(w, "Invalid webhook id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.WebhookSvc.Delete(r.Context(), userID, webhookID); err != nil {
+		if errors.Is(err, service.ErrWebhookNotFound) {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to delete webhook %d for user %d: %v", webhookID, userID, err)
+		http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Webhook の送信結果を新しい順に取得
+// ?limit=50 (最大200)
+func (h *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+	webhookID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || webhookID <= 0 {
+		http.Error(w, "Invalid webhook id", http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > 200 {
+			http.Error(w, "limit must be between 1 and 200", http.StatusBadRequest)
+			return
+		}
+	}
+
+	deliveries, err := h.WebhookSvc.ListDeliveries(r.Context(), userID, webhookID, limit)
+	if err != nil {
+		if errors.Is(err, service.ErrWebhookNotFound) {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to fetch deliveries of webhook %d for user %d: %v", webhookID, userID, err)
+		http.Error(w, "Failed to fetch webhook deliveries", http.StatusInternalServerError)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []model.WebhookDelivery{}
+	}
+
+	resp := struct {
+		Data []model.WebhookDelivery `json:"data"`
+	}{
+		Data: deliveries,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
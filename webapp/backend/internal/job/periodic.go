@@ -0,0 +1,52 @@
+package job
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// 一定間隔で実行するバックグラウンド処理
+type Periodic struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// ctx がキャンセルされるまで Interval ごとに Run を実行する
+// 起動直後にも1回実行する。Interval が 0 以下の場合は何もしない
+func (p *Periodic) Start(ctx context.Context) {
+	if p.Interval <= 0 {
+		log.Printf("[%s] disabled", p.Name)
+		return
+	}
+
+	go func() {
+		log.Printf("[%s] started (interval=%s)", p.Name, p.Interval)
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+
+		for {
+			p.runOnce(ctx)
+			select {
+			case <-ctx.Done():
+				log.Printf("[%s] stopped", p.Name)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (p *Periodic) runOnce(ctx context.Context) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[%s] panic: %v", p.Name, r)
+		}
+	}()
+
+	start := time.Now()
+	if err := p.Run(ctx); err != nil {
+		log.Printf("[%s] failed after %s: %v", p.Name, time.Since(start), err)
+	}
+}
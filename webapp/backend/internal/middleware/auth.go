@@ -4,18 +4,28 @@ import (
 	"context"
 	"log"
 	"net/http"
-
-	"backend/internal/repository"
+	"time"
 )
 
 type contextKey string
 
-const userContextKey contextKey = "user"
+const (
+	userContextKey    contextKey = "user"
+	sessionContextKey contextKey = "session"
+)
 
-func UserAuthMiddleware(sessionRepo *repository.SessionRepository) func(http.Handler) http.Handler {
+// セッションIDからユーザーIDを引く
+// 有効期限を延長した場合は延長後の有効期限を返す。延長しなかった場合はゼロ値
+type SessionAuthenticator interface {
+	Authenticate(ctx context.Context, sessionID string) (int, time.Time, error)
+}
+
+// セッションを検証し、ユーザーIDとセッションIDをコンテキストにセットする
+// 有効期限が延長された場合はCookieを再発行する
+func UserAuthMiddleware(sessions SessionAuthenticator, sessionCookie SessionCookie) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie("session_id")
+			cookie, err := r.Cookie(SessionCookieName)
 			if err != nil {
 				log.Printf("Error retrieving session cookie: %v", err)
 				http.Error(w, "Unauthorized: No session cookie", http.StatusUnauthorized)
@@ -23,14 +33,18 @@ func UserAuthMiddleware(sessionRepo *repository.SessionRepository) func(http.Han
 			}
 			sessionID := cookie.Value
 
-			userID, err := sessionRepo.FindUserBySessionID(r.Context(), sessionID)
+			userID, renewedUntil, err := sessions.Authenticate(r.Context(), sessionID)
 			if err != nil {
 				log.Printf("Error finding user by session ID: %v", err)
 				http.Error(w, "Unauthorized: Invalid session", http.StatusUnauthorized)
 				return
 			}
+			if !renewedUntil.IsZero() {
+				sessionCookie.Set(w, sessionID, renewedUntil)
+			}
 
 			ctx := context.WithValue(r.Context(), userContextKey, userID)
+			ctx = context.WithValue(ctx, sessionContextKey, sessionID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -50,9 +64,31 @@ func RobotAuthMiddleware(validAPIKey string) func(http.Handler) http.Handler {
 	}
 }
 
+// 管理者用API(商品カタログの一括更新など)の認証
+func AdminAuthMiddleware(validAPIKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey := r.Header.Get("X-ADMIN-API-KEY")
+
+			if apiKey == "" || apiKey != validAPIKey {
+				http.Error(w, "Forbidden: Invalid or missing admin API key", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // コンテキストからユーザー情報を取得
 // ユーザ情報はUserAuthMiddleware
 func GetUserFromContext(ctx context.Context) (int, bool) {
 	userID, ok := ctx.Value(userContextKey).(int)
 	return userID, ok
 }
+
+// コンテキストからリクエストに使われたセッションIDを取得
+// セッションIDはUserAuthMiddlewareでセットされる
+func GetSessionFromContext(ctx context.Context) (string, bool) {
+	sessionID, ok := ctx.Value(sessionContextKey).(string)
+	return sessionID, ok
+}
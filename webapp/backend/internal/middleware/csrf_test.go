@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	handler := CSRFMiddleware([]string{"https://admin.example.com/"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{name: "safe method from other site", method: http.MethodGet, headers: map[string]string{"Origin": "https://evil.example"}, want: http.StatusOK},
+		{name: "non-browser client", method: http.MethodPost, want: http.StatusOK},
+		{name: "same origin", method: http.MethodPost, headers: map[string]string{"Origin": "http://example.com"}, want: http.StatusOK},
+		{name: "same origin is case-insensitive", method: http.MethodPost, headers: map[string]string{"Origin": "http://EXAMPLE.com"}, want: http.StatusOK},
+		{name: "trusted origin", method: http.MethodDelete, headers: map[string]string{"Origin": "https://Admin.example.com"}, want: http.StatusOK},
+		{name: "cross origin", method: http.MethodPost, headers: map[string]string{"Origin": "https://evil.example"}, want: http.StatusForbidden},
+		{name: "trusted host with other scheme", method: http.MethodPost, headers: map[string]string{"Origin": "http://admin.example.com"}, want: http.StatusForbidden},
+		{name: "same origin referer", method: http.MethodPut, headers: map[string]string{"Referer": "http://example.com/orders?page=2"}, want: http.StatusOK},
+		{name: "cross origin referer", method: http.MethodPut, headers: map[string]string{"Referer": "https://evil.example/form"}, want: http.StatusForbidden},
+		{name: "origin takes precedence over referer", method: http.MethodPost, headers: map[string]string{"Origin": "https://evil.example", "Referer": "http://example.com/"}, want: http.StatusForbidden},
+		{name: "null origin", method: http.MethodPost, headers: map[string]string{"Origin": "null"}, want: http.StatusForbidden},
+		{name: "null origin with same origin referer", method: http.MethodPost, headers: map[string]string{"Origin": "null", "Referer": "http://example.com/"}, want: http.StatusOK},
+		{name: "sec-fetch-site same-origin", method: http.MethodPost, headers: map[string]string{"Sec-Fetch-Site": "same-origin"}, want: http.StatusOK},
+		{name: "sec-fetch-site none", method: http.MethodPost, headers: map[string]string{"Sec-Fetch-Site": "none"}, want: http.StatusOK},
+		{name: "sec-fetch-site same-site", method: http.MethodPost, headers: map[string]string{"Sec-Fetch-Site": "same-site"}, want: http.StatusForbidden},
+		{name: "sec-fetch-site cross-site", method: http.MethodPost, headers: map[string]string{"Sec-Fetch-Site": "cross-site"}, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// httptest.NewRequest の Host は example.com
+			req := httptest.NewRequest(tt.method, "/api/v1/orders", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+const SessionCookieName = "session_id"
+
+// セッションIDのCookieの属性
+type SessionCookie struct {
+	// HTTPS でのみ送信する
+	Secure   bool
+	SameSite http.SameSite
+	// 空の場合はリクエストのホストのみ
+	Domain string
+}
+
+// セッションIDのCookieを発行する
+func (c SessionCookie) Set(w http.ResponseWriter, sessionID string, expiresAt time.Time) {
+	http.SetCookie(w, c.cookie(sessionID, expiresAt))
+}
+
+// セッションIDのCookieを消去する
+func (c SessionCookie) Clear(w http.ResponseWriter) {
+	cookie := c.cookie("", time.Time{})
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
+
+func (c SessionCookie) cookie(value string, expiresAt time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    value,
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   c.Secure,
+		SameSite: c.SameSite,
+		Domain:   c.Domain,
+		Path:     "/",
+	}
+}
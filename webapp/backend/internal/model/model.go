@@ -18,6 +18,33 @@ type Product struct {
 	Weight      int    `db:"weight"       json:"weight"`
 	Image       string `db:"image"        json:"image"`
 	Description string `db:"description"  json:"description"`
+	// 所属カテゴリごとのルートからのパス
+	Breadcrumbs [][]CategoryRef `db:"-" json:"breadcrumbs,omitempty"`
+	// セッションユーザーのお気に入りかどうか
+	IsFavorite bool `db:"-" json:"is_favorite"`
+}
+
+type Category struct {
+	CategoryID int         `db:"category_id" json:"category_id"`
+	ParentID   *int        `db:"parent_id"   json:"parent_id,omitempty"`
+	Name       string      `db:"name"        json:"name"`
+	Children   []*Category `db:"-"           json:"children"`
+}
+
+type CategoryRef struct {
+	CategoryID int    `json:"category_id"`
+	Name       string `json:"name"`
+}
+
+type ProductCategory struct {
+	ProductID  int `db:"product_id"`
+	CategoryID int `db:"category_id"`
+}
+
+type Tag struct {
+	TagID        int    `db:"tag_id"        json:"tag_id"`
+	Name         string `db:"name"          json:"name"`
+	ProductCount int    `db:"product_count" json:"product_count"`
 }
 
 type Order struct {
@@ -30,9 +57,63 @@ type Order struct {
 	Value         int          `db:"value"           json:"value"`
 	CreatedAt     time.Time    `db:"created_at"      json:"created_at"`
 	ArrivedAt     sql.NullTime `db:"arrived_at"      json:"arrived_at"`
+	CheckoutID    *int64       `db:"checkout_id"     json:"checkout_id,omitempty"`
+	// 注文を引き受けた配送計画
+	DeliveryPlanID *int64 `db:"delivery_plan_id" json:"delivery_plan_id,omitempty"`
+	// 発送日時を指定した注文のみ
+	ShipAfter *time.Time `db:"ship_after" json:"ship_after,omitempty"`
+}
+
+// 注文の詳細
+type OrderDetail struct {
+	Order
+	Product      Product              `json:"product"`
+	DeliveryPlan *DeliveryPlanSummary `json:"delivery_plan"`
+	// ステータスの変更履歴(古い順)
+	Timeline []OrderStatusEvent `json:"timeline"`
+}
+
+type OrderStatusEvent struct {
+	ShippedStatus string    `db:"shipped_status" json:"shipped_status"`
+	ChangedAt     time.Time `db:"changed_at"     json:"changed_at"`
+}
+
+// 保存済みの配送計画(注文一覧は含まない)
+type DeliveryPlanSummary struct {
+	DeliveryPlanID int64     `db:"delivery_plan_id" json:"plan_id"`
+	RobotID        string    `db:"robot_id"         json:"robot_id"`
+	TotalWeight    int       `db:"total_weight"     json:"total_weight"`
+	TotalValue     int       `db:"total_value"      json:"total_value"`
+	CreatedAt      time.Time `db:"created_at"       json:"created_at"`
+}
+
+// 1回の注文操作(注文ヘッダー)
+type Checkout struct {
+	CheckoutID int64          `db:"checkout_id" json:"checkout_id"`
+	UserID     int            `db:"user_id"     json:"-"`
+	TotalValue int            `db:"total_value" json:"total_value"`
+	CreatedAt  time.Time      `db:"created_at"  json:"created_at"`
+	Items      []CheckoutItem `db:"-"           json:"items"`
+	// 発送日時を指定した注文のみ
+	ShipAfter *time.Time `db:"-" json:"ship_after,omitempty"`
+	// 明細に含まれる注文(数量1単位)のステータスごとの件数
+	StatusCounts map[string]int `db:"-" json:"status_counts,omitempty"`
+}
+
+// 注文明細
+type CheckoutItem struct {
+	CheckoutItemID int64    `db:"checkout_item_id" json:"-"`
+	CheckoutID     int64    `db:"checkout_id"      json:"-"`
+	ProductID      int      `db:"product_id"       json:"product_id"`
+	ProductName    string   `db:"product_name"     json:"product_name"`
+	Quantity       int      `db:"quantity"         json:"quantity"`
+	UnitValue      int      `db:"unit_value"       json:"unit_value"`
+	Subtotal       int      `db:"subtotal"         json:"subtotal"`
+	OrderIDs       []string `db:"-"                json:"order_ids,omitempty"`
 }
 
 type DeliveryPlan struct {
+	PlanID      int64   `json:"plan_id,omitempty"`
 	RobotID     string  `json:"robot_id"`
 	TotalWeight int     `json:"total_weight"`
 	TotalValue  int     `json:"total_value"`
@@ -46,6 +127,16 @@ type LoginRequest struct {
 
 type CreateOrderRequest struct {
 	Items []RequestItem `json:"items"`
+	// 指定した場合、この日時まで配送計画の対象にしない
+	ShipAfter *time.Time `json:"ship_after,omitempty"`
+}
+
+// 注文明細の誤り。Index はリクエストの items 内の位置
+type OrderItemError struct {
+	Index     int    `json:"index"`
+	ProductID int    `json:"product_id"`
+	Field     string `json:"field"`
+	Message   string `json:"message"`
 }
 
 type RequestItem struct {
@@ -66,4 +157,199 @@ type ListRequest struct {
 	SortField string `json:"sort_field"`
 	SortOrder string `json:"sort_order"`
 	Offset    int    `json:"-"`
+
+	// 商品一覧のみ。カテゴリは配下のカテゴリも含めて絞り込む
+	CategoryID int    `json:"category_id"`
+	Tag        string `json:"tag"`
+	// CategoryID とその配下のカテゴリID。サービス層で設定する
+	CategoryIDs []int `json:"-"`
+
+	// 注文履歴のみ。"checkout" を指定すると注文ヘッダー単位で返す
+	GroupBy string `json:"group_by"`
+	// 注文履歴のみ。日時は RFC3339 または "2006-01-02" 形式
+	Statuses    []string `json:"statuses"`
+	CreatedFrom string   `json:"created_from"`
+	CreatedTo   string   `json:"created_to"`
+	ArrivedFrom string   `json:"arrived_from"`
+	ArrivedTo   string   `json:"arrived_to"`
+	// 上記の絞り込み条件を検証した値。ハンドラで設定する
+	Filter OrderFilter `json:"-"`
+}
+
+// 注文履歴の絞り込み条件
+// From は指定日時を含み、To は指定日時を含まない
+type OrderFilter struct {
+	Statuses    []string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	ArrivedFrom *time.Time
+	ArrivedTo   *time.Time
+}
+
+// 商品カタログ一括インポートの結果
+type ProductImportReport struct {
+	DryRun    bool                 `json:"dry_run"`
+	Applied   bool                 `json:"applied"`
+	Created   int                  `json:"created"`
+	Updated   int                  `json:"updated"`
+	Unchanged int                  `json:"unchanged"`
+	Changes   []ProductChange      `json:"changes"`
+	Errors    []ProductImportError `json:"errors"`
+}
+
+// インポートによる1行分の変更内容
+// Action は create, update, unchanged のいずれか
+type ProductChange struct {
+	Row       int      `json:"row"`
+	ProductID int      `json:"product_id,omitempty"`
+	Action    string   `json:"action"`
+	Fields    []string `json:"fields,omitempty"`
+}
+
+type ProductImportError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// 商品一覧キャッシュの統計情報
+type ProductCacheStats struct {
+	Hits          uint64  `json:"hits"`
+	Misses        uint64  `json:"misses"`
+	SharedLoads   uint64  `json:"shared_loads"`
+	Invalidations uint64  `json:"invalidations"`
+	Entries       int     `json:"entries"`
+	HitRatio      float64 `json:"hit_ratio"`
+}
+
+// 期限切れセッション削除ジョブの実行状況
+// 値はこのプロセスで実行した分のみ
+type SessionPurgeStats struct {
+	Runs            uint64 `json:"runs"`
+	SkippedRuns     uint64 `json:"skipped_runs"`
+	FailedRuns      uint64 `json:"failed_runs"`
+	DeletedSessions uint64 `json:"deleted_sessions"`
+	// 最後に実行したときの結果
+	LastRunAt      *time.Time `json:"last_run_at"`
+	LastDeleted    int64      `json:"last_deleted"`
+	LastDurationMs int64      `json:"last_duration_ms"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+// おすすめ商品
+// Score は注文回数または一緒に注文された回数
+type RecommendedProduct struct {
+	Product
+	Score int `db:"score" json:"score"`
+}
+
+// 注文作成APIの冪等キーと保存済みのレスポンス
+type IdempotencyRecord struct {
+	UserID       int       `db:"user_id"`
+	Key          string    `db:"idempotency_key"`
+	RequestHash  string    `db:"request_hash"`
+	ResponseBody []byte    `db:"response_body"`
+	ExpiresAt    time.Time `db:"expires_at"`
+}
+
+// ユーザーの注文数の上限と現在の利用状況
+// 上限が 0 の項目は無制限で、残りは null になる
+type OrderQuota struct {
+	MaxUnitsPerRequest   int       `json:"max_units_per_request"`
+	MaxUnitsPerDay       int       `json:"max_units_per_day"`
+	MaxOutstandingUnits  int       `json:"max_outstanding_units"`
+	UnitsToday           int       `json:"units_today"`
+	OutstandingUnits     int       `json:"outstanding_units"`
+	RemainingToday       *int      `json:"remaining_today"`
+	RemainingOutstanding *int      `json:"remaining_outstanding"`
+	ResetsAt             time.Time `json:"resets_at"`
+}
+
+// 注文の集計結果
+type OrderStats struct {
+	From         time.Time          `json:"from"`
+	To           time.Time          `json:"to"`
+	Interval     string             `json:"interval"`
+	StatusCounts map[string]int     `json:"status_counts"`
+	Series       []OrderStatsPeriod `json:"series"`
+	// 注文から到着までの平均秒数。配送が完了した注文がない場合は null
+	AverageLeadTimeSeconds *float64 `json:"average_lead_time_seconds"`
+}
+
+type OrderStatsPeriod struct {
+	// 日ごとの場合は "2006-01-02"、月ごとの場合は "2006-01"
+	Period      string `db:"period"       json:"period"`
+	Orders      int    `db:"orders"       json:"orders"`
+	TotalValue  int64  `db:"total_value"  json:"total_value"`
+	TotalWeight int64  `db:"total_weight" json:"total_weight"`
+}
+
+// 注文ステータス変更の通知先
+type Webhook struct {
+	WebhookID int64  `db:"webhook_id" json:"webhook_id"`
+	UserID    int    `db:"user_id"    json:"-"`
+	URL       string `db:"url"        json:"url"`
+	// 登録時のレスポンスでのみ返す
+	Secret    string    `db:"secret"     json:"secret,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// 送信待ちの Webhook イベント
+type WebhookEvent struct {
+	EventID       int64     `db:"event_id"`
+	WebhookID     int64     `db:"webhook_id"`
+	URL           string    `db:"url"`
+	Secret        string    `db:"secret"`
+	EventType     string    `db:"event_type"`
+	OrderID       int64     `db:"order_id"`
+	ShippedStatus string    `db:"shipped_status"`
+	OccurredAt    time.Time `db:"occurred_at"`
+	Attempts      int       `db:"attempts"`
+}
+
+// Webhook の送信結果
+type WebhookDelivery struct {
+	DeliveryID int64     `db:"delivery_id" json:"delivery_id"`
+	EventID    int64     `db:"event_id"    json:"event_id"`
+	WebhookID  int64     `db:"webhook_id"  json:"webhook_id"`
+	EventType  string    `db:"event_type"  json:"event_type"`
+	OrderID    int64     `db:"order_id"    json:"order_id"`
+	Attempt    int       `db:"attempt"     json:"attempt"`
+	StatusCode *int      `db:"status_code" json:"status_code"`
+	Error      *string   `db:"error"       json:"error"`
+	DurationMS int64     `db:"duration_ms" json:"duration_ms"`
+	CreatedAt  time.Time `db:"created_at"  json:"created_at"`
+}
+
+// 再注文の結果
+type ReorderResult struct {
+	Checkout *Checkout
+	Replayed bool
+	// 現在は注文できないため除外した商品
+	Skipped []SkippedItem
+}
+
+type SkippedItem struct {
+	ProductID int    `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+	Reason    string `json:"reason"`
+}
+
+// ログイン中のセッション
+type Session struct {
+	ID        int64     `db:"id"           json:"session_id"`
+	SessionID string    `db:"session_uuid" json:"-"`
+	UserID    int       `db:"user_id"      json:"-"`
+	CreatedAt time.Time `db:"created_at"   json:"created_at"`
+	ExpiresAt time.Time `db:"expires_at"   json:"expires_at"`
+	UserAgent string    `db:"user_agent"   json:"user_agent"`
+	IPAddress string    `db:"ip_address"   json:"ip_address"`
+	// リクエストに使われたセッションかどうか
+	Current bool `db:"-" json:"current"`
+}
+
+// 失効させたセッション
+type SessionRevocation struct {
+	RevocationID int64  `db:"revocation_id"`
+	SessionID    string `db:"session_uuid"`
 }
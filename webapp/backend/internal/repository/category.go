@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type CategoryRepository struct {
+	db DBTX
+}
+
+func NewCategoryRepository(db DBTX) *CategoryRepository {
+	return &CategoryRepository{db: db}
+}
+
+// 全カテゴリを取得する
+// 件数が少ないため、階層の組み立てはアプリケーション側で行う
+func (r *CategoryRepository) ListAll(ctx context.Context) ([]model.Category, error) {
+	var categories []model.Category
+	query := "SELECT category_id, parent_id, name FROM categories ORDER BY category_id"
+	if err := r.db.SelectContext(ctx, &categories, query); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// 指定した商品が所属するカテゴリを取得する
+func (r *CategoryRepository) ListByProductIDs(ctx context.Context, productIDs []int) ([]model.ProductCategory, error) {
+	if len(productIDs) == 0 {
+		return nil, nil
+	}
+	query, args, err := sqlx.In(`
+		SELECT product_id, category_id
+		FROM product_categories
+		WHERE product_id IN (?)
+		ORDER BY product_id, category_id`, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	var rows []model.ProductCategory
+	if err := r.db.SelectContext(ctx, &rows, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
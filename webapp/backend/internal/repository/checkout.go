@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type CheckoutRepository struct {
+	db DBTX
+}
+
+func NewCheckoutRepository(db DBTX) *CheckoutRepository {
+	return &CheckoutRepository{db: db}
+}
+
+// 注文ヘッダーと明細を作成する
+// checkout と明細の ID は作成後の値で更新する
+func (r *CheckoutRepository) Create(ctx context.Context, checkout *model.Checkout) error {
+	result, err := r.db.ExecContext(ctx,
+		"INSERT INTO checkouts (user_id, total_value, created_at) VALUES (?, ?, NOW())",
+		checkout.UserID, checkout.TotalValue)
+	if err != nil {
+		return err
+	}
+	checkoutID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	checkout.CheckoutID = checkoutID
+	if len(checkout.Items) == 0 {
+		return nil
+	}
+
+	query := "INSERT INTO checkout_items (checkout_id, product_id, quantity, unit_value) VALUES "
+	args := make([]any, 0, len(checkout.Items)*4)
+	vals := make([]string, 0, len(checkout.Items))
+	for _, item := range checkout.Items {
+		vals = append(vals, "(?, ?, ?, ?)")
+		args = append(args, checkoutID, item.ProductID, item.Quantity, item.UnitValue)
+	}
+	result, err = r.db.ExecContext(ctx, query+strings.Join(vals, ","), args...)
+	if err != nil {
+		return err
+	}
+	firstID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for i := range checkout.Items {
+		checkout.Items[i].CheckoutID = checkoutID
+		checkout.Items[i].CheckoutItemID = firstID + int64(i)
+	}
+	return nil
+}
+
+// ユーザーの注文ヘッダーを取得する
+// 存在しない、または他のユーザーの注文ヘッダーの場合は nil を返す
+func (r *CheckoutRepository) FindByID(ctx context.Context, userID int, checkoutID int64) (*model.Checkout, error) {
+	var checkout model.Checkout
+	query := "SELECT checkout_id, user_id, total_value, created_at FROM checkouts WHERE checkout_id = ? AND user_id = ?"
+	if err := r.db.GetContext(ctx, &checkout, query, checkoutID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &checkout, nil
+}
+
+// ユーザーの注文ヘッダー一覧を取得する(明細は含まない)
+// search を指定した場合は、その文字列を商品名に含む明細を持つものに絞り込む
+func (r *CheckoutRepository) ListByUser(ctx context.Context, userID int, req model.ListRequest) ([]model.Checkout, int, error) {
+	where := "WHERE c.user_id = ?"
+	args := []any{userID}
+	if search := strings.TrimSpace(req.Search); search != "" {
+		pattern := "%" + search + "%"
+		if req.Type == "prefix" {
+			pattern = search + "%"
+		}
+		where += ` AND EXISTS (
+			SELECT 1 FROM checkout_items ci
+			JOIN products p ON p.product_id = ci.product_id
+			WHERE ci.checkout_id = c.checkout_id AND p.name LIKE ?)`
+		args = append(args, pattern)
+	}
+	// 注文履歴の絞り込み条件は、条件に合う注文を含む注文ヘッダーに適用する
+	filterConds, filterArgs, err := orderFilterConds("o", req.Filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(filterConds) > 0 {
+		where += ` AND EXISTS (
+			SELECT 1 FROM orders o
+			WHERE o.checkout_id = c.checkout_id AND ` + strings.Join(filterConds, " AND ") + ")"
+		args = append(args, filterArgs...)
+	}
+
+	sortCols := map[string]string{
+		"checkout_id": "c.checkout_id",
+		"order_id":    "c.checkout_id",
+		"created_at":  "c.created_at",
+		"total_value": "c.total_value",
+	}
+	sortColumn, ok := sortCols[strings.ToLower(req.SortField)]
+	if !ok {
+		sortColumn = "c.checkout_id"
+	}
+	sortDirection := "ASC"
+	if strings.ToUpper(req.SortOrder) == "DESC" {
+		sortDirection = "DESC"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT c.checkout_id, c.user_id, c.total_value, c.created_at
+		FROM checkouts c
+		%s
+		ORDER BY %s %s, c.checkout_id ASC
+		LIMIT ? OFFSET ?`, where, sortColumn, sortDirection)
+	var checkouts []model.Checkout
+	dataArgs := append(append([]any{}, args...), req.PageSize, req.Offset)
+	if err := r.db.SelectContext(ctx, &checkouts, query, dataArgs...); err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := r.db.GetContext(ctx, &total, "SELECT COUNT(*) FROM checkouts c "+where, args...); err != nil {
+		return nil, 0, err
+	}
+	return checkouts, total, nil
+}
+
+// 注文ヘッダーごとの明細を取得する
+func (r *CheckoutRepository) ListItems(ctx context.Context, checkoutIDs []int64) ([]model.CheckoutItem, error) {
+	if len(checkoutIDs) == 0 {
+		return nil, nil
+	}
+	query, args, err := sqlx.In(`
+		SELECT
+			ci.checkout_item_id,
+			ci.checkout_id,
+			ci.product_id,
+			p.name AS product_name,
+			ci.quantity,
+			ci.unit_value,
+			ci.quantity * ci.unit_value AS subtotal
+		FROM checkout_items ci
+		JOIN products p ON p.product_id = ci.product_id
+		WHERE ci.checkout_id IN (?)
+		ORDER BY ci.checkout_id, ci.checkout_item_id`, checkoutIDs)
+	if err != nil {
+		return nil, err
+	}
+	var items []model.CheckoutItem
+	if err := r.db.SelectContext(ctx, &items, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// 注文ヘッダーごとに、紐づく注文のステータス別件数を取得する
+func (r *CheckoutRepository) CountStatuses(ctx context.Context, checkoutIDs []int64) (map[int64]map[string]int, error) {
+	counts := make(map[int64]map[string]int)
+	if len(checkoutIDs) == 0 {
+		return counts, nil
+	}
+	query, args, err := sqlx.In(`
+		SELECT checkout_id, shipped_status, COUNT(*) AS cnt
+		FROM orders
+		WHERE checkout_id IN (?)
+		GROUP BY checkout_id, shipped_status`, checkoutIDs)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		CheckoutID    int64  `db:"checkout_id"`
+		ShippedStatus string `db:"shipped_status"`
+		Count         int    `db:"cnt"`
+	}
+	if err := r.db.SelectContext(ctx, &rows, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if counts[row.CheckoutID] == nil {
+			counts[row.CheckoutID] = make(map[string]int)
+		}
+		counts[row.CheckoutID][row.ShippedStatus] = row.Count
+	}
+	return counts, nil
+}
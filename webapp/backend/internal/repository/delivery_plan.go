@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"database/sql"
+	"errors"
+)
+
+type DeliveryPlanRepository struct {
+	db DBTX
+}
+
+func NewDeliveryPlanRepository(db DBTX) *DeliveryPlanRepository {
+	return &DeliveryPlanRepository{db: db}
+}
+
+// 配送計画を保存し、生成されたIDを返す
+func (r *DeliveryPlanRepository) Create(ctx context.Context, plan *model.DeliveryPlan) (int64, error) {
+	query := `INSERT INTO delivery_plans (robot_id, total_weight, total_value, created_at) VALUES (?, ?, ?, NOW())`
+	result, err := r.db.ExecContext(ctx, query, plan.RobotID, plan.TotalWeight, plan.TotalValue)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (r *DeliveryPlanRepository) FindByID(ctx context.Context, planID int64) (*model.DeliveryPlanSummary, error) {
+	var plan model.DeliveryPlanSummary
+	query := `
+		SELECT delivery_plan_id, robot_id, total_weight, total_value, created_at
+		FROM delivery_plans
+		WHERE delivery_plan_id = ?`
+	if err := r.db.GetContext(ctx, &plan, query, planID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &plan, nil
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type FavoriteRepository struct {
+	db DBTX
+}
+
+func NewFavoriteRepository(db DBTX) *FavoriteRepository {
+	return &FavoriteRepository{db: db}
+}
+
+// お気に入りに追加する
+// 既に追加済みの場合は何もせず false を返す
+func (r *FavoriteRepository) Add(ctx context.Context, userID, productID int) (bool, error) {
+	query := "INSERT IGNORE INTO user_favorites (user_id, product_id, created_at) VALUES (?, ?, NOW())"
+	result, err := r.db.ExecContext(ctx, query, userID, productID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r *FavoriteRepository) Remove(ctx context.Context, userID, productID int) error {
+	query := "DELETE FROM user_favorites WHERE user_id = ? AND product_id = ?"
+	_, err := r.db.ExecContext(ctx, query, userID, productID)
+	return err
+}
+
+// お気に入りの商品を追加日時の新しい順に取得する
+func (r *FavoriteRepository) ListProducts(ctx context.Context, userID, limit, offset int) ([]model.Product, int, error) {
+	var products []model.Product
+	query := `
+		SELECT p.product_id, p.name, p.value, p.weight, p.image, p.description
+		FROM user_favorites f
+		JOIN products p ON p.product_id = f.product_id
+		WHERE f.user_id = ?
+		ORDER BY f.created_at DESC, f.product_id DESC
+		LIMIT ? OFFSET ?`
+	if err := r.db.SelectContext(ctx, &products, query, userID, limit, offset); err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	countQuery := "SELECT COUNT(*) FROM user_favorites WHERE user_id = ?"
+	if err := r.db.GetContext(ctx, &total, countQuery, userID); err != nil {
+		return nil, 0, err
+	}
+	return products, total, nil
+}
+
+// 指定した商品のうち、お気に入りに登録されているものを返す
+func (r *FavoriteRepository) FindFavoriteProductIDs(ctx context.Context, userID int, productIDs []int) (map[int]bool, error) {
+	favorites := make(map[int]bool)
+	if len(productIDs) == 0 {
+		return favorites, nil
+	}
+	query, args, err := sqlx.In(`
+		SELECT product_id
+		FROM user_favorites
+		WHERE user_id = ? AND product_id IN (?)`, userID, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	if err := r.db.SelectContext(ctx, &ids, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		favorites[id] = true
+	}
+	return favorites, nil
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type IdempotencyRepository struct {
+	db DBTX
+}
+
+func NewIdempotencyRepository(db DBTX) *IdempotencyRepository {
+	return &IdempotencyRepository{db: db}
+}
+
+// 冪等キーを登録する
+// 同じキーが既にある場合は false を返す
+// 別のトランザクションが同じキーを登録中の場合は、そのトランザクションが終わるまで待つ
+func (r *IdempotencyRepository) Insert(ctx context.Context, userID int, key, requestHash string, expiresAt time.Time) (bool, error) {
+	query := `
+		INSERT IGNORE INTO idempotency_keys (user_id, idempotency_key, request_hash, created_at, expires_at)
+		VALUES (?, ?, ?, NOW(), ?)`
+	result, err := r.db.ExecContext(ctx, query, userID, key, requestHash, expiresAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// 冪等キーを取得する
+// トランザクション内で最新の値を読むため、行ロックを取得する
+func (r *IdempotencyRepository) FindForUpdate(ctx context.Context, userID int, key string) (*model.IdempotencyRecord, error) {
+	var rec model.IdempotencyRecord
+	query := `
+		SELECT user_id, idempotency_key, request_hash, response_body, expires_at
+		FROM idempotency_keys
+		WHERE user_id = ? AND idempotency_key = ?
+		FOR UPDATE`
+	if err := r.db.GetContext(ctx, &rec, query, userID, key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (r *IdempotencyRepository) Delete(ctx context.Context, userID int, key string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ?", userID, key)
+	return err
+}
+
+func (r *IdempotencyRepository) SaveResponse(ctx context.Context, userID int, key string, responseBody []byte) error {
+	query := "UPDATE idempotency_keys SET response_body = ? WHERE user_id = ? AND idempotency_key = ?"
+	_, err := r.db.ExecContext(ctx, query, responseBody, userID, key)
+	return err
+}
+
+// 期限切れの冪等キーを最大 limit 件削除し、削除件数を返す
+func (r *IdempotencyRepository) DeleteExpired(ctx context.Context, now time.Time, limit int) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= ? LIMIT ?", now, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
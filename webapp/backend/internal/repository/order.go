@@ -4,8 +4,10 @@ import (
 	"backend/internal/model"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -29,24 +31,227 @@ func (r *OrderRepository) Create(ctx context.Context, order *model.Order) (strin
 	if err != nil {
 		return "", err
 	}
+	if err := r.recordCreated(ctx, id, id); err != nil {
+		return "", err
+	}
 	return fmt.Sprintf("%d", id), nil
 }
 
-// 複数の注文IDのステータスを一括で更新
-// 主に配送ロボットが注文を引き受けた際に一括更新をするために使用
-func (r *OrderRepository) UpdateStatuses(ctx context.Context, orderIDs []int64, newStatus string) error {
+// 複数の注文IDのうち fromStatuses のいずれかの注文のステータスを newStatus に一括で更新し、更新した注文のIDを返す
+// 主に配送ロボットが配送状況を報告した際に使用
+// fromStatuses 以外の注文と既に newStatus の注文は更新せず、履歴・通知も書き込まない
+// 履歴も書き込むため、トランザクション内で呼び出す
+func (r *OrderRepository) UpdateStatuses(ctx context.Context, orderIDs []int64, fromStatuses []string, newStatus string) ([]int64, error) {
+	if len(orderIDs) == 0 || len(fromStatuses) == 0 {
+		return nil, nil
+	}
+	query, args, err := sqlx.In(
+		"SELECT order_id FROM orders WHERE order_id IN (?) AND shipped_status IN (?) AND shipped_status <> ? FOR UPDATE",
+		orderIDs, fromStatuses, newStatus)
+	if err != nil {
+		return nil, err
+	}
+	var changedIDs []int64
+	if err := r.db.SelectContext(ctx, &changedIDs, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	if len(changedIDs) == 0 {
+		return nil, nil
+	}
+
+	// 配送完了した注文には到着日時を記録する
+	set := "shipped_status = ?"
+	if newStatus == "completed" {
+		set += ", arrived_at = NOW()"
+	}
+	query, args, err = sqlx.In("UPDATE orders SET "+set+" WHERE order_id IN (?)", newStatus, changedIDs)
+	if err != nil {
+		return nil, err
+	}
+	query = r.db.Rebind(query)
+	if _, err = r.db.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+	return changedIDs, r.recordStatuses(ctx, changedIDs)
+}
+
+// 配送ロボットが引き受けた注文を配送中にし、配送計画に紐づける
+// 履歴も書き込むため、トランザクション内で呼び出す
+func (r *OrderRepository) AssignDeliveryPlan(ctx context.Context, planID int64, orderIDs []int64) error {
 	if len(orderIDs) == 0 {
 		return nil
 	}
-	query, args, err := sqlx.In("UPDATE orders SET shipped_status = ? WHERE order_id IN (?)", newStatus, orderIDs)
+	query, args, err := sqlx.In("UPDATE orders SET shipped_status = 'delivering', delivery_plan_id = ? WHERE order_id IN (?)", planID, orderIDs)
 	if err != nil {
 		return err
 	}
-	query = r.db.Rebind(query)
-	_, err = r.db.ExecContext(ctx, query, args...)
+	if _, err = r.db.ExecContext(ctx, r.db.Rebind(query), args...); err != nil {
+		return err
+	}
+	return r.recordStatuses(ctx, orderIDs)
+}
+
+// 注文の現在のステータスを履歴に追加し、注文したユーザーの Webhook への通知を登録する
+// ステータスを変更した注文のIDのみを渡す
+// 通知はステータスの変更と同じトランザクションで登録するため、変更が取り消された場合は送信されない
+func (r *OrderRepository) recordStatuses(ctx context.Context, orderIDs []int64) error {
+	query, args, err := sqlx.In(`
+		INSERT INTO order_status_history (order_id, shipped_status, changed_at)
+		SELECT order_id, shipped_status, NOW() FROM orders WHERE order_id IN (?)`, orderIDs)
+	if err != nil {
+		return err
+	}
+	if _, err = r.db.ExecContext(ctx, r.db.Rebind(query), args...); err != nil {
+		return err
+	}
+
+	query, args, err = sqlx.In(`
+		INSERT INTO webhook_events (webhook_id, event_type, order_id, shipped_status, occurred_at, next_attempt_at)
+		SELECT w.webhook_id, 'order.status_changed', o.order_id, o.shipped_status, NOW(), NOW()
+		FROM orders o
+		JOIN webhooks w ON w.user_id = o.user_id
+		WHERE o.order_id IN (?)`, orderIDs)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, r.db.Rebind(query), args...)
+	return err
+}
+
+// 作成した注文の最初のステータスを、作成日時で履歴に追加する
+func (r *OrderRepository) recordCreated(ctx context.Context, firstID, lastID int64) error {
+	query := `
+		INSERT INTO order_status_history (order_id, shipped_status, changed_at)
+		SELECT order_id, shipped_status, created_at FROM orders WHERE order_id BETWEEN ? AND ?`
+	_, err := r.db.ExecContext(ctx, query, firstID, lastID)
 	return err
 }
 
+// ユーザーの注文を1件取得する
+// 注文が存在しない、または他のユーザーの注文の場合は nil を返す
+func (r *OrderRepository) FindByID(ctx context.Context, userID int, orderID int64) (*model.Order, error) {
+	var order model.Order
+	query := `
+		SELECT o.order_id, o.user_id, o.product_id, p.name AS product_name, o.shipped_status,
+			p.weight, p.value, o.created_at, o.arrived_at, o.checkout_id, o.delivery_plan_id, o.ship_after
+		FROM orders o
+		JOIN products p ON p.product_id = o.product_id
+		WHERE o.order_id = ? AND o.user_id = ?`
+	if err := r.db.GetContext(ctx, &order, query, orderID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &order, nil
+}
+
+// 注文のステータス変更履歴を古い順に取得する
+func (r *OrderRepository) ListStatusHistory(ctx context.Context, orderID int64) ([]model.OrderStatusEvent, error) {
+	var events []model.OrderStatusEvent
+	query := `
+		SELECT shipped_status, changed_at
+		FROM order_status_history
+		WHERE order_id = ?
+		ORDER BY changed_at, order_status_history_id`
+	if err := r.db.SelectContext(ctx, &events, query, orderID); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// 配送計画に含める注文のうち、まだ出荷待ち(shipping)のものに行ロックをかけて返す
+// 計画の作成中にキャンセルされた注文や他のロボットが引き受けた注文は含まれない
+func (r *OrderRepository) LockShippingOrders(ctx context.Context, orderIDs []int64) ([]int64, error) {
+	if len(orderIDs) == 0 {
+		return nil, nil
+	}
+	query, args, err := sqlx.In("SELECT order_id FROM orders WHERE order_id IN (?) AND shipped_status = 'shipping' FOR UPDATE", orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	var locked []int64
+	if err := r.db.SelectContext(ctx, &locked, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	return locked, nil
+}
+
+// ユーザーの注文をキャンセルする
+// ロボットが引き受ける前(shipping, scheduled)の注文のみキャンセルでき、キャンセルした場合は true を返す
+// 履歴も書き込むため、トランザクション内で呼び出す
+func (r *OrderRepository) Cancel(ctx context.Context, userID int, orderID int64) (bool, error) {
+	query := "UPDATE orders SET shipped_status = 'cancelled' WHERE order_id = ? AND user_id = ? AND shipped_status IN ('shipping', 'scheduled')"
+	result, err := r.db.ExecContext(ctx, query, orderID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+	return true, r.recordStatuses(ctx, []int64{orderID})
+}
+
+// ユーザーの注文の現在のステータスを取得する
+// 注文が存在しない、または他のユーザーの注文の場合は空文字を返す
+func (r *OrderRepository) FindStatus(ctx context.Context, userID int, orderID int64) (string, error) {
+	var status string
+	err := r.db.GetContext(ctx, &status, "SELECT shipped_status FROM orders WHERE order_id = ? AND user_id = ?", orderID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return status, err
+}
+
+// 注文の現在のステータスを取得する
+// 注文が存在しない場合は空文字を返す
+func (r *OrderRepository) FindStatusByOrderID(ctx context.Context, orderID int64) (string, error) {
+	var status string
+	err := r.db.GetContext(ctx, &status, "SELECT shipped_status FROM orders WHERE order_id = ?", orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return status, err
+}
+
+// since 以降にユーザーが注文した数量(注文の件数)を数える
+// キャンセルされた注文は数えない
+func (r *OrderRepository) CountUnitsSince(ctx context.Context, userID int, since time.Time) (int, error) {
+	var n int
+	query := "SELECT COUNT(*) FROM orders WHERE user_id = ? AND created_at >= ? AND shipped_status <> 'cancelled'"
+	err := r.db.GetContext(ctx, &n, query, userID, since)
+	return n, err
+}
+
+// ロボットがまだ引き受けていないユーザーの注文の件数を数える
+// 発送予約中(scheduled)の注文も、発送日時になると上限を確認せず shipping になるため含める
+func (r *OrderRepository) CountOutstandingUnits(ctx context.Context, userID int) (int, error) {
+	var n int
+	query := "SELECT COUNT(*) FROM orders WHERE user_id = ? AND shipped_status IN ('shipping', 'scheduled')"
+	err := r.db.GetContext(ctx, &n, query, userID)
+	return n, err
+}
+
+// 発送日時を過ぎた scheduled の注文を最大 limit 件取得し、行ロックをかける
+// 複数のバックエンドで同時に実行しても同じ注文を取得しないよう、ロック中の行は飛ばす
+func (r *OrderRepository) LockDueScheduledOrders(ctx context.Context, now time.Time, limit int) ([]int64, error) {
+	var ids []int64
+	query := `
+		SELECT order_id FROM orders
+		WHERE shipped_status = 'scheduled' AND ship_after <= ?
+		ORDER BY ship_after
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED`
+	if err := r.db.SelectContext(ctx, &ids, query, now, limit); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
 // 配送中(shipped_status:shipping)の注文一覧を取得
 func (r *OrderRepository) GetShippingOrders(ctx context.Context) ([]model.Order, error) {
 	var orders []model.Order
@@ -76,39 +281,9 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 		req.Offset = (req.Page - 1) * req.PageSize
 	}
 
-	//当てはまらないカラムを弾くためのホワイトリスト
-	sortCols := map[string]string{
-		"order_id":       "o.order_id",
-		"product_name":   "p.name",
-		"created_at":     "o.created_at",
-		"shipped_status": "o.shipped_status",
-		"arrived_at":     "o.arrived_at",
-	}
-	sortColumn, ok := sortCols[strings.ToLower(req.SortField)]
-	if !ok {
-		sortColumn = "o.order_id"
-	}
-	sortDirection := "ASC"
-	if strings.ToUpper(req.SortOrder) == "DESC" {
-		sortDirection = "DESC"
-	}
-
-	baseFromWhere := `
-		FROM orders o
-		JOIN products p ON p.product_id = o.product_id
-		WHERE o.user_id = ?
-	`
-	queryArgs := []any{userID}
-
-	search := strings.TrimSpace(req.Search)
-	if search != "" {
-		if req.Type == "prefix" {
-			baseFromWhere += " AND p.name LIKE ?"
-			queryArgs = append(queryArgs, search+"%")
-		} else {
-			baseFromWhere += " AND p.name LIKE ?"
-			queryArgs = append(queryArgs, "%"+search+"%")
-		}
+	baseFromWhere, queryArgs, sortColumn, sortDirection, err := orderListQuery(userID, req, "")
+	if err != nil {
+		return nil, 0, err
 	}
 
 	// JOIN
@@ -119,7 +294,9 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 			p.name AS product_name,
 			o.shipped_status,
 			o.created_at,
-			o.arrived_at
+			o.arrived_at,
+			o.checkout_id,
+			o.ship_after
 		%s
 		ORDER BY %s %s, o.order_id ASC
 		LIMIT ? OFFSET ?`, baseFromWhere, sortColumn, sortDirection)
@@ -133,6 +310,8 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 		ShippedStatus string       `db:"shipped_status"`
 		CreatedAt     sql.NullTime `db:"created_at"`
 		ArrivedAt     sql.NullTime `db:"arrived_at"`
+		CheckoutID    *int64       `db:"checkout_id"`
+		ShipAfter     *time.Time   `db:"ship_after"`
 	}
 
 	var ordersRaw []orderRow
@@ -150,6 +329,8 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 			ShippedStatus: row.ShippedStatus,
 			CreatedAt:     row.CreatedAt.Time, // NULL の可能性があるなら model 側を sql.NullTime に
 			ArrivedAt:     row.ArrivedAt,
+			CheckoutID:    row.CheckoutID,
+			ShipAfter:     row.ShipAfter,
 		})
 	}
 
@@ -163,6 +344,102 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 	return orders, total, nil
 }
 
+// 注文履歴一覧と出力で共通の FROM/WHERE 句と並び順を組み立てる
+// 注文時点の単価(ci.unit_value)を引くための JOIN
+// 注文ヘッダー内の商品は一意なので、注文の行は増えない。注文ヘッダーのない注文では NULL になる
+const orderCheckoutItemJoin = "LEFT JOIN checkout_items ci ON ci.checkout_id = o.checkout_id AND ci.product_id = o.product_id"
+
+// joins は products の後に追加する JOIN 句
+func orderListQuery(userID int, req model.ListRequest, joins string) (string, []any, string, string, error) {
+	//当てはまらないカラムを弾くためのホワイトリスト
+	sortCols := map[string]string{
+		"order_id":       "o.order_id",
+		"product_name":   "p.name",
+		"created_at":     "o.created_at",
+		"shipped_status": "o.shipped_status",
+		"arrived_at":     "o.arrived_at",
+	}
+	sortColumn, ok := sortCols[strings.ToLower(req.SortField)]
+	if !ok {
+		sortColumn = "o.order_id"
+	}
+	sortDirection := "ASC"
+	if strings.ToUpper(req.SortOrder) == "DESC" {
+		sortDirection = "DESC"
+	}
+
+	baseFromWhere := `
+		FROM orders o
+		JOIN products p ON p.product_id = o.product_id
+		` + joins + `
+		WHERE o.user_id = ?
+	`
+	queryArgs := []any{userID}
+
+	search := strings.TrimSpace(req.Search)
+	if search != "" {
+		if req.Type == "prefix" {
+			baseFromWhere += " AND p.name LIKE ?"
+			queryArgs = append(queryArgs, search+"%")
+		} else {
+			baseFromWhere += " AND p.name LIKE ?"
+			queryArgs = append(queryArgs, "%"+search+"%")
+		}
+	}
+
+	filterConds, filterArgs, err := orderFilterConds("o", req.Filter)
+	if err != nil {
+		return "", nil, "", "", err
+	}
+	for _, cond := range filterConds {
+		baseFromWhere += " AND " + cond
+	}
+	queryArgs = append(queryArgs, filterArgs...)
+
+	return baseFromWhere, queryArgs, sortColumn, sortDirection, nil
+}
+
+// 注文履歴を一覧と同じ条件・並び順で1件ずつ fn に渡す
+// 全件をメモリに載せないようカーソルで読み進める。ページングは行わない
+// 金額は集計と同じく注文時点の単価とし、注文ヘッダーのない注文は現在の単価を使う
+func (r *OrderRepository) ForEachOrder(ctx context.Context, userID int, req model.ListRequest, fn func(o model.Order) error) error {
+	fromWhere, args, sortColumn, sortDirection, err := orderListQuery(userID, req, orderCheckoutItemJoin)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`
+		SELECT
+			o.order_id,
+			o.user_id,
+			o.product_id,
+			p.name AS product_name,
+			COALESCE(ci.unit_value, p.value) AS value,
+			p.weight,
+			o.shipped_status,
+			o.created_at,
+			o.arrived_at,
+			o.checkout_id
+		%s
+		ORDER BY %s %s, o.order_id ASC`, fromWhere, sortColumn, sortDirection)
+
+	rows, err := r.db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o model.Order
+		if err := rows.StructScan(&o); err != nil {
+			return err
+		}
+		if err := fn(o); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
 // １回のINSERTで複数行挿入を行う
 func (r *OrderRepository) CreateBulk(ctx context.Context, orders []model.Order) ([]string, error) {
 	if len(orders) == 0 {
@@ -170,13 +447,18 @@ func (r *OrderRepository) CreateBulk(ctx context.Context, orders []model.Order)
 	}
 
 	// MySQL の場合、1回の INSERT で複数行挿入
-	query := "INSERT INTO orders (user_id, product_id, shipped_status, created_at) VALUES "
-	args := make([]any, 0, len(orders)*2)
+	// 発送日時を指定した注文はその日時まで scheduled にする
+	query := "INSERT INTO orders (user_id, product_id, checkout_id, shipped_status, ship_after, created_at) VALUES "
+	args := make([]any, 0, len(orders)*5)
 	vals := make([]string, 0, len(orders))
 
 	for _, o := range orders {
-		vals = append(vals, "(?, ?, 'shipping', NOW())")
-		args = append(args, o.UserID, o.ProductID)
+		status := "shipping"
+		if o.ShipAfter != nil {
+			status = "scheduled"
+		}
+		vals = append(vals, "(?, ?, ?, ?, ?, NOW())")
+		args = append(args, o.UserID, o.ProductID, o.CheckoutID, status, o.ShipAfter)
 	}
 
 	query += strings.Join(vals, ",")
@@ -189,6 +471,9 @@ func (r *OrderRepository) CreateBulk(ctx context.Context, orders []model.Order)
 	if err != nil {
 		return nil, err
 	}
+	if err := r.recordCreated(ctx, firstID, firstID+int64(len(orders))-1); err != nil {
+		return nil, err
+	}
 
 	// 連番で ID を計算して返す
 	ids := make([]string, len(orders))
@@ -197,3 +482,34 @@ func (r *OrderRepository) CreateBulk(ctx context.Context, orders []model.Order)
 	}
 	return ids, nil
 }
+
+// 注文履歴の絞り込み条件を alias の orders テーブルに対する WHERE 句の条件にする
+func orderFilterConds(alias string, f model.OrderFilter) ([]string, []any, error) {
+	var conds []string
+	var args []any
+	if len(f.Statuses) > 0 {
+		cond, inArgs, err := sqlx.In(alias+".shipped_status IN (?)", f.Statuses)
+		if err != nil {
+			return nil, nil, err
+		}
+		conds = append(conds, cond)
+		args = append(args, inArgs...)
+	}
+	if f.CreatedFrom != nil {
+		conds = append(conds, alias+".created_at >= ?")
+		args = append(args, *f.CreatedFrom)
+	}
+	if f.CreatedTo != nil {
+		conds = append(conds, alias+".created_at < ?")
+		args = append(args, *f.CreatedTo)
+	}
+	if f.ArrivedFrom != nil {
+		conds = append(conds, alias+".arrived_at >= ?")
+		args = append(args, *f.ArrivedFrom)
+	}
+	if f.ArrivedTo != nil {
+		conds = append(conds, alias+".arrived_at < ?")
+		args = append(args, *f.ArrivedTo)
+	}
+	return conds, args, nil
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// 集計の単位ごとの DATE_FORMAT の書式
+var orderStatsPeriodFormats = map[string]string{
+	"day":   "%Y-%m-%d",
+	"month": "%Y-%m",
+}
+
+// 注文日時が [from, to) の注文をステータスごとに数える
+func (r *OrderRepository) CountByStatus(ctx context.Context, userID int, from, to time.Time) (map[string]int, error) {
+	var rows []struct {
+		ShippedStatus string `db:"shipped_status"`
+		Count         int    `db:"count"`
+	}
+	query := `
+		SELECT shipped_status, COUNT(*) AS count
+		FROM orders
+		WHERE user_id = ? AND created_at >= ? AND created_at < ?
+		GROUP BY shipped_status`
+	if err := r.db.SelectContext(ctx, &rows, query, userID, from, to); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.ShippedStatus] = row.Count
+	}
+	return counts, nil
+}
+
+// 注文日時が [from, to) の注文の件数・金額・重量を interval ("day" または "month") ごとに集計する
+// キャンセルされた注文は含めない
+// 金額は注文時点の単価で集計する。注文ヘッダーのない注文は現在の単価を使う
+func (r *OrderRepository) AggregateByPeriod(ctx context.Context, userID int, from, to time.Time, interval string) ([]model.OrderStatsPeriod, error) {
+	format, ok := orderStatsPeriodFormats[interval]
+	if !ok {
+		return nil, fmt.Errorf("unsupported interval: %q", interval)
+	}
+	query := fmt.Sprintf(`
+		SELECT
+			DATE_FORMAT(o.created_at, '%s') AS period,
+			COUNT(*) AS orders,
+			SUM(COALESCE(ci.unit_value, p.value)) AS total_value,
+			SUM(p.weight) AS total_weight
+		FROM orders o
+		JOIN products p ON p.product_id = o.product_id
+		%s
+		WHERE o.user_id = ? AND o.created_at >= ? AND o.created_at < ? AND o.shipped_status <> 'cancelled'
+		GROUP BY period
+		ORDER BY period`, format, orderCheckoutItemJoin)
+	var periods []model.OrderStatsPeriod
+	if err := r.db.SelectContext(ctx, &periods, query, userID, from, to); err != nil {
+		return nil, err
+	}
+	return periods, nil
+}
+
+// 注文日時が [from, to) で配送が完了した注文の、注文から到着までの平均秒数を返す
+// 対象の注文がない場合は nil を返す
+func (r *OrderRepository) AverageLeadTime(ctx context.Context, userID int, from, to time.Time) (*float64, error) {
+	var avg sql.NullFloat64
+	query := `
+		SELECT AVG(TIMESTAMPDIFF(SECOND, created_at, arrived_at))
+		FROM orders
+		WHERE user_id = ? AND created_at >= ? AND created_at < ? AND arrived_at IS NOT NULL`
+	if err := r.db.GetContext(ctx, &avg, query, userID, from, to); err != nil {
+		return nil, err
+	}
+	if !avg.Valid {
+		return nil, nil
+	}
+	return &avg.Float64, nil
+}
@@ -3,6 +3,9 @@ package repository
 import (
 	"backend/internal/model"
 	"context"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
 )
 
 type ProductRepository struct {
@@ -20,14 +23,56 @@ func (r *ProductRepository) ListProducts(ctx context.Context, userID int, req mo
 		SELECT product_id, name, value, weight, image, description
 		FROM products
 	`
-	where := ""
+	conds := []string{}
 	args := []interface{}{}
 	if req.Search != "" {
-		where += " WHERE (name LIKE ? OR description LIKE ?)"
+		conds = append(conds, "(name LIKE ? OR description LIKE ?)")
 		searchPattern := "%" + req.Search + "%"
 		args = append(args, searchPattern, searchPattern)
 	}
+	if len(req.CategoryIDs) > 0 {
+		cond, condArgs, err := sqlx.In(`product_id IN (
+			SELECT pc.product_id FROM product_categories pc WHERE pc.category_id IN (?))`, req.CategoryIDs)
+		if err != nil {
+			return nil, 0, err
+		}
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+	}
+	if req.Tag != "" {
+		conds = append(conds, `product_id IN (
+			SELECT pt.product_id FROM product_tags pt
+			JOIN tags t ON t.tag_id = pt.tag_id
+			WHERE t.name = ?)`)
+		args = append(args, req.Tag)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	req = NormalizeProductListRequest(req)
+
+	baseQuery += where + " ORDER BY " + req.SortField + " " + req.SortOrder + " LIMIT ? OFFSET ?"
+	dataArgs := append(append([]interface{}{}, args...), req.PageSize, req.Offset)
+
+	err := r.db.SelectContext(ctx, &products, r.db.Rebind(baseQuery), dataArgs...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	countQuery := "SELECT COUNT(*) FROM products" + where
+	var total int
+	if err := r.db.GetContext(ctx, &total, r.db.Rebind(countQuery), args...); err != nil {
+		return nil, 0, err
+	}
 
+	return products, total, nil
+}
+
+// 商品一覧の検索条件を ListProducts が実際に使う値に揃える
+// 同じ結果になるリクエストは同じ値になるため、キャッシュのキーにも使える
+func NormalizeProductListRequest(req model.ListRequest) model.ListRequest {
 	if req.PageSize <= 0 || req.PageSize > 200 {
 		req.PageSize = 50
 	}
@@ -35,10 +80,6 @@ func (r *ProductRepository) ListProducts(ctx context.Context, userID int, req mo
 		req.Offset = 0
 	}
 
-	// デフォルトのソート設定
-	sortField := "product_id"
-	sortOrder := "ASC"
-
 	// 有効なソートフィールドの検証
 	validSortFields := map[string]bool{
 		"product_id": true,
@@ -46,28 +87,87 @@ func (r *ProductRepository) ListProducts(ctx context.Context, userID int, req mo
 		"value":      true,
 		"weight":     true,
 	}
-
-	if req.SortField != "" && validSortFields[req.SortField] {
-		sortField = req.SortField
+	if req.SortField == "" || !validSortFields[req.SortField] {
+		req.SortField = "product_id"
 	}
 
 	if req.SortOrder == "DESC" {
-		sortOrder = "DESC"
+		req.SortOrder = "DESC"
+	} else {
+		req.SortOrder = "ASC"
 	}
+	return req
+}
 
-	baseQuery += where + " ORDER BY " + sortField + " " + sortOrder + " LIMIT ? OFFSET ?"
-	dataArgs := append(append([]interface{}{}, args...), req.PageSize, req.Offset)
-
-	err := r.db.SelectContext(ctx, &products, baseQuery, dataArgs...)
+// 指定したIDの商品を取得する
+func (r *ProductRepository) FindByIDs(ctx context.Context, productIDs []int) ([]model.Product, error) {
+	if len(productIDs) == 0 {
+		return nil, nil
+	}
+	query, args, err := sqlx.In(`
+		SELECT product_id, name, value, weight, image, description
+		FROM products
+		WHERE product_id IN (?)`, productIDs)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
+	var products []model.Product
+	if err := r.db.SelectContext(ctx, &products, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
 
-	countQuery := "SELECT COUNT(*) FROM products" + where
-	var total int
-	if err := r.db.GetContext(ctx, &total, countQuery, args...); err != nil {
-		return nil, 0, err
+// 商品を一括で登録・更新する
+// product_id が 0 の行は新規作成、それ以外は product_id をキーに上書きする
+func (r *ProductRepository) UpsertBulk(ctx context.Context, products []model.Product) error {
+	if len(products) == 0 {
+		return nil
 	}
 
-	return products, total, nil
+	query := "INSERT INTO products (product_id, name, value, weight, image, description) VALUES "
+	args := make([]any, 0, len(products)*6)
+	vals := make([]string, 0, len(products))
+	for _, p := range products {
+		vals = append(vals, "(?, ?, ?, ?, ?, ?)")
+		var id any
+		if p.ProductID > 0 {
+			id = p.ProductID
+		}
+		args = append(args, id, p.Name, p.Value, p.Weight, p.Image, p.Description)
+	}
+	query += strings.Join(vals, ",")
+	query += ` ON DUPLICATE KEY UPDATE
+		name = VALUES(name),
+		value = VALUES(value),
+		weight = VALUES(weight),
+		image = VALUES(image),
+		description = VALUES(description)`
+
+	_, err := r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// 全商品を product_id 順に1件ずつ fn に渡す
+// 全件をメモリに載せないようカーソルで読み進める
+func (r *ProductRepository) ForEach(ctx context.Context, fn func(p model.Product) error) error {
+	rows, err := r.db.QueryxContext(ctx, `
+		SELECT product_id, name, value, weight, image, description
+		FROM products
+		ORDER BY product_id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p model.Product
+		if err := rows.StructScan(&p); err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
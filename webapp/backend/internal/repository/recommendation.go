@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"time"
+)
+
+type RecommendationRepository struct {
+	db DBTX
+}
+
+func NewRecommendationRepository(db DBTX) *RecommendationRepository {
+	return &RecommendationRepository{db: db}
+}
+
+// since 以降の注文からユーザーごとの注文回数を集計し直す
+// キャンセルされた注文は数えない
+// 注文ヘッダー(checkout_id)ごとに1回として数える
+// 注文ヘッダーのない古い注文は、同じユーザーが同じ日時に作成した注文を1回の注文として数える
+func (r *RecommendationRepository) RebuildUserProductStats(ctx context.Context, since time.Time) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM user_product_stats"); err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO user_product_stats (user_id, product_id, order_count, last_ordered_at)
+		SELECT
+			user_id,
+			product_id,
+			COUNT(DISTINCT checkout_id) + COUNT(DISTINCT CASE WHEN checkout_id IS NULL THEN created_at END),
+			MAX(created_at)
+		FROM orders
+		WHERE created_at >= ? AND shipped_status <> 'cancelled'
+		GROUP BY user_id, product_id`
+	_, err := r.db.ExecContext(ctx, query, since)
+	return err
+}
+
+// since 以降の注文から商品の組み合わせ回数を集計し直す
+// 同じ注文ヘッダーの商品を組み合わせる。注文ヘッダーのない古い注文はユーザーと注文日時で組み合わせ、回数を足し合わせる
+func (r *RecommendationRepository) RebuildCooccurrences(ctx context.Context, since time.Time) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM product_cooccurrences"); err != nil {
+		return err
+	}
+	// 数量分の行を重複して数えないよう、先に注文ごとの商品を一意にしてから組み合わせる
+	query := `
+		INSERT INTO product_cooccurrences (product_id, related_product_id, co_count)
+		SELECT a.product_id, b.product_id, COUNT(*)
+		FROM (
+			SELECT DISTINCT checkout_id, product_id FROM orders
+			WHERE created_at >= ? AND checkout_id IS NOT NULL AND shipped_status <> 'cancelled'
+		) a
+		JOIN (
+			SELECT DISTINCT checkout_id, product_id FROM orders
+			WHERE created_at >= ? AND checkout_id IS NOT NULL AND shipped_status <> 'cancelled'
+		) b ON b.checkout_id = a.checkout_id AND b.product_id <> a.product_id
+		GROUP BY a.product_id, b.product_id`
+	if _, err := r.db.ExecContext(ctx, query, since, since); err != nil {
+		return err
+	}
+
+	legacyQuery := `
+		INSERT INTO product_cooccurrences (product_id, related_product_id, co_count)
+		SELECT * FROM (
+			SELECT a.product_id, b.product_id AS related_product_id, COUNT(*) AS pair_count
+			FROM (
+				SELECT DISTINCT user_id, created_at, product_id FROM orders
+				WHERE created_at >= ? AND checkout_id IS NULL AND shipped_status <> 'cancelled'
+			) a
+			JOIN (
+				SELECT DISTINCT user_id, created_at, product_id FROM orders
+				WHERE created_at >= ? AND checkout_id IS NULL AND shipped_status <> 'cancelled'
+			) b ON b.user_id = a.user_id AND b.created_at = a.created_at AND b.product_id <> a.product_id
+			GROUP BY a.product_id, b.product_id
+		) legacy
+		ON DUPLICATE KEY UPDATE co_count = co_count + legacy.pair_count`
+	_, err := r.db.ExecContext(ctx, legacyQuery, since, since)
+	return err
+}
+
+// ユーザーがよく注文する商品を回数の多い順に取得する
+func (r *RecommendationRepository) ListFrequentlyOrdered(ctx context.Context, userID, limit int) ([]model.RecommendedProduct, error) {
+	var products []model.RecommendedProduct
+	query := `
+		SELECT p.product_id, p.name, p.value, p.weight, p.image, p.description, s.order_count AS score
+		FROM user_product_stats s
+		JOIN products p ON p.product_id = s.product_id
+		WHERE s.user_id = ?
+		ORDER BY s.order_count DESC, s.last_ordered_at DESC
+		LIMIT ?`
+	if err := r.db.SelectContext(ctx, &products, query, userID, limit); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// 指定した商品と一緒に注文されることの多い商品を取得する
+func (r *RecommendationRepository) ListCoOrdered(ctx context.Context, productID, limit int) ([]model.RecommendedProduct, error) {
+	var products []model.RecommendedProduct
+	query := `
+		SELECT p.product_id, p.name, p.value, p.weight, p.image, p.description, c.co_count AS score
+		FROM product_cooccurrences c
+		JOIN products p ON p.product_id = c.related_product_id
+		WHERE c.product_id = ?
+		ORDER BY c.co_count DESC, c.related_product_id ASC
+		LIMIT ?`
+	if err := r.db.SelectContext(ctx, &products, query, productID, limit); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
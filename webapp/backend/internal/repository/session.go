@@ -1,12 +1,19 @@
 package repository
 
 import (
+	"backend/internal/model"
 	"context"
+	"database/sql"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// 保存する User-Agent の最大長(user_sessions.user_agent の長さ)
+const maxSessionUserAgentLength = 512
+
 type SessionRepository struct {
 	db DBTX
 }
@@ -16,34 +23,168 @@ func NewSessionRepository(db DBTX) *SessionRepository {
 }
 
 // セッションを作成し、セッションIDと有効期限を返す
-func (r *SessionRepository) Create(ctx context.Context, userBusinessID int, duration time.Duration) (string, time.Time, error) {
+func (r *SessionRepository) Create(ctx context.Context, userBusinessID int, duration time.Duration, userAgent, ipAddress string) (string, time.Time, error) {
 	sessionUUID, err := uuid.NewRandom()
 	if err != nil {
 		return "", time.Time{}, err
 	}
-	expiresAt := time.Now().Add(duration)
+	now := time.Now()
+	expiresAt := now.Add(duration)
 	sessionIDStr := sessionUUID.String()
+	if len(userAgent) > maxSessionUserAgentLength {
+		userAgent = userAgent[:maxSessionUserAgentLength]
+	}
 
-	query := "INSERT INTO user_sessions (session_uuid, user_id, expires_at) VALUES (?, ?, ?)"
-	_, err = r.db.ExecContext(ctx, query, sessionIDStr, userBusinessID, expiresAt)
+	query := "INSERT INTO user_sessions (session_uuid, user_id, expires_at, created_at, user_agent, ip_address) VALUES (?, ?, ?, ?, ?, ?)"
+	_, err = r.db.ExecContext(ctx, query, sessionIDStr, userBusinessID, expiresAt, now, userAgent, ipAddress)
 	if err != nil {
 		return "", time.Time{}, err
 	}
 	return sessionIDStr, expiresAt, nil
 }
 
-// セッションIDからユーザーIDを取得
-func (r *SessionRepository) FindUserBySessionID(ctx context.Context, sessionID string) (int, error) {
-	var userID int
+// 有効なセッションを取得
+func (r *SessionRepository) FindActiveSession(ctx context.Context, sessionID string) (model.Session, error) {
+	var session model.Session
 	query := `
 		SELECT 
-			u.user_id
+			s.id, s.session_uuid, u.user_id, s.created_at, s.expires_at, s.user_agent, s.ip_address
 		FROM users u
 		JOIN user_sessions s ON u.user_id = s.user_id
 		WHERE s.session_uuid = ? AND s.expires_at > ?`
-	err := r.db.GetContext(ctx, &userID, query, sessionID, time.Now())
+	err := r.db.GetContext(ctx, &session, query, sessionID, time.Now())
+	if err != nil {
+		return model.Session{}, err
+	}
+	return session, nil
+}
+
+// 有効期限が renewBefore 以前の有効なセッションの有効期限を expiresAt に延長する
+// 他のリクエストが延長済みの場合は false を返す
+func (r *SessionRepository) Renew(ctx context.Context, sessionID string, expiresAt, renewBefore time.Time) (bool, error) {
+	query := "UPDATE user_sessions SET expires_at = ? WHERE session_uuid = ? AND expires_at > ? AND expires_at <= ? AND expires_at < ?"
+	result, err := r.db.ExecContext(ctx, query, expiresAt, sessionID, time.Now(), renewBefore, expiresAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// ユーザーの有効なセッションを新しい順に取得する
+func (r *SessionRepository) ListByUser(ctx context.Context, userID int) ([]model.Session, error) {
+	var sessions []model.Session
+	query := `
+		SELECT id, session_uuid, user_id, created_at, expires_at, user_agent, ip_address
+		FROM user_sessions
+		WHERE user_id = ? AND expires_at > ?
+		ORDER BY created_at DESC, id DESC`
+	if err := r.db.SelectContext(ctx, &sessions, query, userID, time.Now()); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// セッションIDのセッションを削除する。削除した場合は true を返す
+func (r *SessionRepository) Delete(ctx context.Context, sessionID string) (bool, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM user_sessions WHERE session_uuid = ?", sessionID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// ユーザーのセッションを削除し、削除したセッションのセッションIDを返す
+// 他のユーザーのセッション、または存在しない場合は空文字を返す
+// 削除する行をロックしてから削除するため、トランザクション内で呼び出す
+func (r *SessionRepository) DeleteByID(ctx context.Context, userID int, id int64) (string, error) {
+	var sessionID string
+	err := r.db.GetContext(ctx, &sessionID, "SELECT session_uuid FROM user_sessions WHERE id = ? AND user_id = ? FOR UPDATE", id, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM user_sessions WHERE id = ? AND user_id = ?", id, userID); err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+// ユーザーの全セッションを削除し、削除したセッションのセッションIDを返す
+// exceptSessionID を指定した場合、そのセッションは残す
+// 返すセッションIDと削除する行が一致するよう、行をロックしてから削除する。トランザクション内で呼び出す
+func (r *SessionRepository) DeleteAllByUser(ctx context.Context, userID int, exceptSessionID string) ([]string, error) {
+	var sessionIDs []string
+	query := "SELECT session_uuid FROM user_sessions WHERE user_id = ? AND session_uuid <> ? FOR UPDATE"
+	if err := r.db.SelectContext(ctx, &sessionIDs, query, userID, exceptSessionID); err != nil {
+		return nil, err
+	}
+	if len(sessionIDs) == 0 {
+		return nil, nil
+	}
+	_, err := r.db.ExecContext(ctx, "DELETE FROM user_sessions WHERE user_id = ? AND session_uuid <> ?", userID, exceptSessionID)
+	if err != nil {
+		return nil, err
+	}
+	return sessionIDs, nil
+}
+
+// 失効させたセッションを記録する。セッションの削除と同じトランザクション内で呼び出す
+// 他のバックエンドが revocation_id の順に読んで取りこぼさないよう、カウンターの行ロックで記録を直列化する
+func (r *SessionRepository) RecordRevocations(ctx context.Context, sessionIDs []string, revokedAt time.Time) error {
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE session_revocation_counter SET revocations = revocations + ? WHERE id = 1", len(sessionIDs))
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO session_revocations (session_uuid, revoked_at) VALUES "
+	args := make([]any, 0, len(sessionIDs)*2)
+	vals := make([]string, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		vals = append(vals, "(?, ?)")
+		args = append(args, sessionID, revokedAt)
+	}
+	_, err = r.db.ExecContext(ctx, query+strings.Join(vals, ","), args...)
+	return err
+}
+
+// revocation_id が afterID より大きい失効を古い順に取得する
+func (r *SessionRepository) ListRevocationsAfter(ctx context.Context, afterID int64) ([]model.SessionRevocation, error) {
+	var revocations []model.SessionRevocation
+	query := "SELECT revocation_id, session_uuid FROM session_revocations WHERE revocation_id > ? ORDER BY revocation_id"
+	if err := r.db.SelectContext(ctx, &revocations, query, afterID); err != nil {
+		return nil, err
+	}
+	return revocations, nil
+}
+
+// before より前の失効の記録を最大 limit 件削除し、削除した件数を返す
+func (r *SessionRepository) DeleteRevocationsBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM session_revocations WHERE revoked_at < ? ORDER BY revoked_at LIMIT ?", before, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// now 時点で期限切れのセッションを期限の古い順に最大 limit 件削除し、削除した件数を返す
+func (r *SessionRepository) DeleteExpired(ctx context.Context, now time.Time, limit int) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM user_sessions WHERE expires_at <= ? ORDER BY expires_at LIMIT ?", now, limit)
 	if err != nil {
 		return 0, err
 	}
-	return userID, nil
+	return result.RowsAffected()
 }
@@ -2,28 +2,73 @@ package repository
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/jmoiron/sqlx"
 )
 
 type Store struct {
-	db          DBTX
-	UserRepo    *UserRepository
-	SessionRepo *SessionRepository
-	ProductRepo *ProductRepository
-	OrderRepo   *OrderRepository
+	db                 DBTX
+	UserRepo           *UserRepository
+	SessionRepo        *SessionRepository
+	ProductRepo        *ProductRepository
+	OrderRepo          *OrderRepository
+	CheckoutRepo       *CheckoutRepository
+	CategoryRepo       *CategoryRepository
+	TagRepo            *TagRepository
+	FavoriteRepo       *FavoriteRepository
+	RecommendationRepo *RecommendationRepository
+	IdempotencyRepo    *IdempotencyRepository
+	DeliveryPlanRepo   *DeliveryPlanRepository
+	WebhookRepo        *WebhookRepository
 }
 
 func NewStore(db DBTX) *Store {
 	return &Store{
-		db:          db,
-		UserRepo:    NewUserRepository(db),
-		SessionRepo: NewSessionRepository(db),
-		ProductRepo: NewProductRepository(db),
-		OrderRepo:   NewOrderRepository(db),
+		db:                 db,
+		UserRepo:           NewUserRepository(db),
+		SessionRepo:        NewSessionRepository(db),
+		ProductRepo:        NewProductRepository(db),
+		OrderRepo:          NewOrderRepository(db),
+		CheckoutRepo:       NewCheckoutRepository(db),
+		CategoryRepo:       NewCategoryRepository(db),
+		TagRepo:            NewTagRepository(db),
+		FavoriteRepo:       NewFavoriteRepository(db),
+		RecommendationRepo: NewRecommendationRepository(db),
+		IdempotencyRepo:    NewIdempotencyRepository(db),
+		DeliveryPlanRepo:   NewDeliveryPlanRepository(db),
+		WebhookRepo:        NewWebhookRepository(db),
 	}
 }
 
+// MySQL の名前付きロックを取得できた場合のみ fn を実行する
+// 複数のバックエンドで同じバッチ処理が同時に動かないようにするために使う
+// ロックを取得できなかった場合は fn を実行せず false を返す
+func (s *Store) TryLock(ctx context.Context, name string, fn func(ctx context.Context) error) (bool, error) {
+	db, ok := s.db.(*sqlx.DB)
+	if !ok {
+		return true, fn(ctx)
+	}
+
+	// 名前付きロックはコネクションに紐づくため、専用のコネクションで取得・解放する
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var acquired sql.NullInt64
+	if err := conn.GetContext(ctx, &acquired, "SELECT GET_LOCK(?, 0)", name); err != nil {
+		return false, err
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		return false, nil
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT RELEASE_LOCK(?)", name)
+
+	return true, fn(ctx)
+}
+
 func (s *Store) ExecTx(ctx context.Context, fn func(txStore *Store) error) error {
 	db, ok := s.db.(*sqlx.DB)
 	if !ok {
@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+)
+
+type TagRepository struct {
+	db DBTX
+}
+
+func NewTagRepository(db DBTX) *TagRepository {
+	return &TagRepository{db: db}
+}
+
+// タグ一覧を商品数とともに取得する
+func (r *TagRepository) ListWithCounts(ctx context.Context) ([]model.Tag, error) {
+	var tags []model.Tag
+	query := `
+		SELECT t.tag_id, t.name, COUNT(pt.product_id) AS product_count
+		FROM tags t
+		LEFT JOIN product_tags pt ON pt.tag_id = t.tag_id
+		GROUP BY t.tag_id, t.name
+		ORDER BY t.name`
+	if err := r.db.SelectContext(ctx, &tags, query); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
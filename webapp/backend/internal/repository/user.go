@@ -31,3 +31,10 @@ func (r *UserRepository) FindByUserName(ctx context.Context, userName string) (*
 	}
 	return &user, nil
 }
+
+// ユーザーの行ロックを取得する
+// 同じユーザーの注文作成を直列にし、注文数の上限を正しく判定するために使う
+func (r *UserRepository) LockByID(ctx context.Context, userID int) error {
+	var id int
+	return r.db.GetContext(ctx, &id, "SELECT user_id FROM users WHERE user_id = ? FOR UPDATE", userID)
+}
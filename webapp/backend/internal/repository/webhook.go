@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type WebhookRepository struct {
+	db DBTX
+}
+
+func NewWebhookRepository(db DBTX) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+func (r *WebhookRepository) Create(ctx context.Context, webhook *model.Webhook) error {
+	result, err := r.db.ExecContext(ctx,
+		"INSERT INTO webhooks (user_id, url, secret, created_at) VALUES (?, ?, ?, ?)",
+		webhook.UserID, webhook.URL, webhook.Secret, webhook.CreatedAt)
+	if err != nil {
+		return err
+	}
+	webhook.WebhookID, err = result.LastInsertId()
+	return err
+}
+
+// ユーザーの Webhook を取得する。署名の鍵は含めない
+func (r *WebhookRepository) ListByUser(ctx context.Context, userID int) ([]model.Webhook, error) {
+	var webhooks []model.Webhook
+	query := "SELECT webhook_id, user_id, url, created_at FROM webhooks WHERE user_id = ? ORDER BY webhook_id"
+	if err := r.db.SelectContext(ctx, &webhooks, query, userID); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (r *WebhookRepository) CountByUser(ctx context.Context, userID int) (int, error) {
+	var n int
+	err := r.db.GetContext(ctx, &n, "SELECT COUNT(*) FROM webhooks WHERE user_id = ?", userID)
+	return n, err
+}
+
+// ユーザーの Webhook を削除する。削除した場合は true を返す
+// 送信待ちのイベントと送信結果も削除される
+func (r *WebhookRepository) Delete(ctx context.Context, userID int, webhookID int64) (bool, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM webhooks WHERE webhook_id = ? AND user_id = ?", webhookID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// 送信時刻を過ぎたイベントを最大 limit 件取得し、lease の間は他の送信処理に取得されないようにする
+// 複数のバックエンドから同時に呼ばれても同じイベントを取得しないよう、トランザクション内で呼び出す
+// 送信中にバックエンドが停止した場合は lease 経過後に再び取得される
+func (r *WebhookRepository) ClaimDueEvents(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]model.WebhookEvent, error) {
+	var events []model.WebhookEvent
+	query := `
+		SELECT e.event_id, e.webhook_id, w.url, w.secret, e.event_type, e.order_id, e.shipped_status, e.occurred_at, e.attempts
+		FROM webhook_events e
+		JOIN webhooks w ON w.webhook_id = e.webhook_id
+		WHERE e.status = 'pending' AND e.next_attempt_at <= ?
+		ORDER BY e.next_attempt_at, e.event_id
+		LIMIT ?
+		FOR UPDATE OF e SKIP LOCKED`
+	if err := r.db.SelectContext(ctx, &events, query, now, limit); err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]int64, len(events))
+	for i, e := range events {
+		ids[i] = e.EventID
+	}
+	update, args, err := sqlx.In("UPDATE webhook_events SET next_attempt_at = ? WHERE event_id IN (?)", now.Add(lease), ids)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.db.ExecContext(ctx, r.db.Rebind(update), args...); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// 送信結果を記録する間 Webhook が削除されないよう、共有ロックを取得する
+// トランザクション内で呼び出す。削除済みの場合は false を返す
+func (r *WebhookRepository) LockWebhook(ctx context.Context, webhookID int64) (bool, error) {
+	var id int64
+	err := r.db.GetContext(ctx, &id, "SELECT webhook_id FROM webhooks WHERE webhook_id = ? FOR SHARE", webhookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *WebhookRepository) MarkDelivered(ctx context.Context, eventID int64, attempts int, deliveredAt time.Time) error {
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE webhook_events SET status = 'delivered', attempts = ?, delivered_at = ? WHERE event_id = ?",
+		attempts, deliveredAt, eventID)
+	return err
+}
+
+func (r *WebhookRepository) ScheduleRetry(ctx context.Context, eventID int64, attempts int, nextAttemptAt time.Time) error {
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE webhook_events SET attempts = ?, next_attempt_at = ? WHERE event_id = ?",
+		attempts, nextAttemptAt, eventID)
+	return err
+}
+
+func (r *WebhookRepository) MarkFailed(ctx context.Context, eventID int64, attempts int) error {
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE webhook_events SET status = 'failed', attempts = ? WHERE event_id = ?",
+		attempts, eventID)
+	return err
+}
+
+func (r *WebhookRepository) CreateDelivery(ctx context.Context, d *model.WebhookDelivery) error {
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (event_id, webhook_id, attempt, status_code, error, duration_ms, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		d.EventID, d.WebhookID, d.Attempt, d.StatusCode, d.Error, d.DurationMS, d.CreatedAt)
+	if err != nil {
+		return err
+	}
+	d.DeliveryID, err = result.LastInsertId()
+	return err
+}
+
+// before より前に発生した送信済み・失敗したイベントを最大 limit 件削除する
+// 送信結果も外部キーにより削除される
+func (r *WebhookRepository) DeleteFinishedEvents(ctx context.Context, before time.Time, limit int) (int64, error) {
+	result, err := r.db.ExecContext(ctx,
+		"DELETE FROM webhook_events WHERE status IN ('delivered', 'failed') AND occurred_at < ? LIMIT ?",
+		before, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ユーザーの Webhook の送信結果を新しい順に取得する
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, userID int, webhookID int64, limit int) ([]model.WebhookDelivery, error) {
+	var deliveries []model.WebhookDelivery
+	query := `
+		SELECT d.delivery_id, d.event_id, d.webhook_id, e.event_type, e.order_id, d.attempt,
+			d.status_code, d.error, d.duration_ms, d.created_at
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.webhook_id = d.webhook_id
+		JOIN webhook_events e ON e.event_id = d.event_id
+		WHERE d.webhook_id = ? AND w.user_id = ?
+		ORDER BY d.delivery_id DESC
+		LIMIT ?`
+	if err := r.db.SelectContext(ctx, &deliveries, query, webhookID, userID, limit); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 環境変数から文字列を取得し、未設定ならデフォルト値を返す
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// 環境変数からカンマ区切りの文字列を取得する
+func getEnvList(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var list []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// 環境変数から整数を取得し、未設定または不正な値ならデフォルト値を返す
+func getEnvInt64(key string, def int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		log.Printf("Warning: %s=%q is not an integer. Using default %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// カンマ区切りの整数リストを取得する
+func getEnvIntList(key string, def []int) []int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var list []int
+	for _, s := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			log.Printf("Warning: %s=%q contains a non-integer value. Using default %v", key, v, def)
+			return def
+		}
+		list = append(list, n)
+	}
+	return list
+}
+
+func getEnvBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Warning: %s=%q is not a boolean. Using default %t", key, v, def)
+		return def
+	}
+	return b
+}
+
+// "30s", "5m" のような time.ParseDuration 形式で取得する
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Warning: %s=%q is not a duration. Using default %s", key, v, def)
+		return def
+	}
+	return d
+}
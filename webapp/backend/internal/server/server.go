@@ -3,12 +3,18 @@ package server
 import (
 	"backend/internal/db"
 	"backend/internal/handler"
+	"backend/internal/job"
 	"backend/internal/middleware"
 	"backend/internal/repository"
 	"backend/internal/service"
+	"backend/internal/storage"
+	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/jmoiron/sqlx"
@@ -17,6 +23,7 @@ import (
 
 type Server struct {
 	Router *chi.Mux
+	jobs   []*job.Periodic
 }
 
 func NewServer() (*Server, *sqlx.DB, error) {
@@ -27,17 +34,85 @@ func NewServer() (*Server, *sqlx.DB, error) {
 
 	store := repository.NewStore(dbConn)
 
-	authService := service.NewAuthService(store)
+	// セッションの検証結果のキャッシュ。他のレプリカでの失効はキャッシュから返す前に確認する
+	sessionCache := service.NewSessionCache(
+		store,
+		int(getEnvInt64("SESSION_CACHE_MAX_ENTRIES", 10000)),
+		getEnvDuration("SESSION_CACHE_TTL", 30*time.Second),
+		getEnvDuration("SESSION_CACHE_NEGATIVE_TTL", 5*time.Second),
+	)
+	// 有効期限が SESSION_RENEW_BEFORE 以内になったら延長する。ログインから SESSION_MAX_LIFETIME を超えては延長しない
+	authService := service.NewAuthService(store, sessionCache, service.SessionConfig{
+		TTL:         getEnvDuration("SESSION_TTL", 24*time.Hour),
+		RenewBefore: getEnvDuration("SESSION_RENEW_BEFORE", 12*time.Hour),
+		MaxLifetime: getEnvDuration("SESSION_MAX_LIFETIME", 7*24*time.Hour),
+	})
+	sessionPurgeService := service.NewSessionPurgeService(store, service.SessionPurgeConfig{
+		BatchSize:  int(getEnvInt64("SESSION_PURGE_BATCH_SIZE", 500)),
+		BatchPause: getEnvDuration("SESSION_PURGE_BATCH_PAUSE", 100*time.Millisecond),
+		// SESSION_CACHE_TTL より長くする
+		RevocationRetention: getEnvDuration("SESSION_REVOCATION_RETENTION", time.Hour),
+	})
 	orderService := service.NewOrderService(store)
-	productService := service.NewProductService(store)
+	productCache := service.NewProductCache(
+		int(getEnvInt64("PRODUCT_CACHE_MAX_ENTRIES", 1000)),
+		getEnvDuration("PRODUCT_CACHE_TTL", 30*time.Second),
+	)
+	// 注文作成APIの冪等キーを保持する期間
+	idempotencyService := service.NewIdempotencyService(store, getEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour))
+	// 注文数の上限。0 は無制限
+	quotaService := service.NewQuotaService(store, service.OrderLimits{
+		MaxUnitsPerRequest:  int(getEnvInt64("ORDER_MAX_UNITS_PER_REQUEST", 0)),
+		MaxUnitsPerDay:      int(getEnvInt64("ORDER_MAX_UNITS_PER_DAY", 0)),
+		MaxOutstandingUnits: int(getEnvInt64("ORDER_MAX_OUTSTANDING_UNITS", 0)),
+	})
+	productService := service.NewProductService(store, productCache, idempotencyService, quotaService)
 	robotService := service.NewRobotService(store)
+	imageStore, err := newImageStore()
+	if err != nil {
+		dbConn.Close()
+		return nil, nil, err
+	}
+	imageService, err := service.NewImageService(
+		imageStore,
+		getEnv("IMAGE_CACHE_DIR", "/tmp/image-cache"),
+		getEnvInt64("IMAGE_CACHE_MAX_BYTES", 256<<20),
+		getEnvInt64("IMAGE_MEMORY_CACHE_MAX_BYTES", 64<<20),
+		getEnvIntList("IMAGE_ALLOWED_SIZES", []int{64, 128, 256, 512}),
+		getEnvDuration("IMAGE_SIGNED_URL_EXPIRY", 0),
+	)
+	if err != nil {
+		dbConn.Close()
+		return nil, nil, err
+	}
 
-	authHandler := handler.NewAuthHandler(authService)
-	productHandler := handler.NewProductHandler(productService)
-	orderHandler := handler.NewOrderHandler(orderService)
+	sessionCookie := newSessionCookie()
+	authHandler := handler.NewAuthHandler(authService, sessionPurgeService, sessionCookie)
+	productHandler := handler.NewProductHandler(productService, imageService)
+	orderHandler := handler.NewOrderHandler(orderService, quotaService)
 	robotHandler := handler.NewRobotHandler(robotService)
+	categoryHandler := handler.NewCategoryHandler(service.NewCategoryService(store))
+	favoriteHandler := handler.NewFavoriteHandler(service.NewFavoriteService(store))
+	recommendationService := service.NewRecommendationService(store, getEnvDuration("RECOMMENDATION_WINDOW", 90*24*time.Hour))
+	recommendationHandler := handler.NewRecommendationHandler(recommendationService)
+	webhookService := service.NewWebhookService(store, service.WebhookConfig{
+		Timeout:     getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		MaxAttempts: int(getEnvInt64("WEBHOOK_MAX_ATTEMPTS", 8)),
+		BaseBackoff: getEnvDuration("WEBHOOK_BASE_BACKOFF", 30*time.Second),
+		MaxBackoff:  getEnvDuration("WEBHOOK_MAX_BACKOFF", 6*time.Hour),
+		BatchSize:   int(getEnvInt64("WEBHOOK_BATCH_SIZE", 100)),
+		Concurrency: int(getEnvInt64("WEBHOOK_CONCURRENCY", 8)),
+		// ループバック・プライベートアドレスへの送信はローカルでの動作確認時のみ許可する
+		AllowPrivateNetworks: getEnvBool("WEBHOOK_ALLOW_PRIVATE_NETWORKS", false),
+		Retention:            getEnvDuration("WEBHOOK_EVENT_RETENTION", 30*24*time.Hour),
+		PurgeBatchSize:       int(getEnvInt64("WEBHOOK_PURGE_BATCH_SIZE", 500)),
+	})
+	webhookHandler := handler.NewWebhookHandler(webhookService)
+	catalogHandler := handler.NewCatalogHandler(service.NewCatalogService(store, productCache), productService)
 
-	userAuthMW := middleware.UserAuthMiddleware(store.SessionRepo)
+	userAuthMW := middleware.UserAuthMiddleware(authService, sessionCookie)
+	// Cookie で認証するAPIのみに適用する。APIキーで認証するロボット・管理者用APIは対象外
+	csrfMW := middleware.CSRFMiddleware(getEnvList("CSRF_TRUSTED_ORIGINS", nil))
 
 	robotAPIKey := os.Getenv("ROBOT_API_KEY")
 	if robotAPIKey == "" {
@@ -46,6 +121,14 @@ func NewServer() (*Server, *sqlx.DB, error) {
 	}
 	robotAuthMW := middleware.RobotAuthMiddleware(robotAPIKey)
 
+	// 管理者用APIはキー未設定なら無効にする
+	var adminAuthMW func(http.Handler) http.Handler
+	if adminAPIKey := os.Getenv("ADMIN_API_KEY"); adminAPIKey != "" {
+		adminAuthMW = middleware.AdminAuthMiddleware(adminAPIKey)
+	} else {
+		log.Println("Warning: ADMIN_API_KEY is not set. Admin API is disabled")
+	}
+
 	r := chi.NewRouter()
 	r.Use(otelchi.Middleware(
 		"backend-api",
@@ -62,29 +145,139 @@ func NewServer() (*Server, *sqlx.DB, error) {
 
 	s := &Server{
 		Router: r,
+		jobs: []*job.Periodic{
+			{
+				Name:     "recommendation-stats",
+				Interval: getEnvDuration("RECOMMENDATION_REFRESH_INTERVAL", 10*time.Minute),
+				Run:      recommendationService.RefreshStats,
+			},
+			{
+				Name:     "idempotency-key-purge",
+				Interval: getEnvDuration("IDEMPOTENCY_KEY_PURGE_INTERVAL", 10*time.Minute),
+				Run:      idempotencyService.PurgeExpired,
+			},
+			{
+				Name:     "scheduled-order-promoter",
+				Interval: getEnvDuration("SCHEDULED_ORDER_PROMOTE_INTERVAL", time.Minute),
+				Run:      orderService.PromoteScheduledOrders,
+			},
+			{
+				Name:     "webhook-dispatch",
+				Interval: getEnvDuration("WEBHOOK_DISPATCH_INTERVAL", 5*time.Second),
+				Run:      webhookService.Dispatch,
+			},
+			{
+				Name:     "webhook-event-purge",
+				Interval: getEnvDuration("WEBHOOK_PURGE_INTERVAL", 10*time.Minute),
+				Run:      webhookService.PurgeFinished,
+			},
+			{
+				Name:     "session-purge",
+				Interval: getEnvDuration("SESSION_PURGE_INTERVAL", 10*time.Minute),
+				Run:      sessionPurgeService.PurgeExpired,
+			},
+		},
 	}
 
-	s.setupRoutes(authHandler, productHandler, orderHandler, robotHandler, userAuthMW, robotAuthMW)
+	s.setupRoutes(authHandler, productHandler, orderHandler, robotHandler, categoryHandler, favoriteHandler, recommendationHandler, catalogHandler, webhookHandler, userAuthMW, csrfMW, robotAuthMW, adminAuthMW)
 
 	return s, dbConn, nil
 }
 
+// IMAGE_STORE に応じて画像の保存先を切り替える
+// fs(デフォルト): IMAGE_DIR 以下のファイル, s3: S3互換ストレージ
+func newImageStore() (storage.ImageStore, error) {
+	switch getEnv("IMAGE_STORE", "fs") {
+	case "fs":
+		return storage.NewFileSystemStore(getEnv("IMAGE_DIR", "/app/images")), nil
+	case "s3":
+		return storage.NewS3Store(storage.S3Config{
+			Endpoint:  getEnv("S3_ENDPOINT", "minio:9000"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Region:    getEnv("S3_REGION", "us-east-1"),
+			Bucket:    getEnv("S3_BUCKET", "images"),
+			Prefix:    os.Getenv("S3_PREFIX"),
+			UseSSL:    getEnvBool("S3_USE_SSL", false),
+			// S3_ENDPOINT はコンテナ内の名前のことがあるため、署名付きURLはブラウザから到達できるこちらで発行する
+			PublicEndpoint: os.Getenv("S3_PUBLIC_ENDPOINT"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown IMAGE_STORE: %q", os.Getenv("IMAGE_STORE"))
+	}
+}
+
+// セッションIDのCookieの属性
+// SESSION_COOKIE_SAMESITE: lax(デフォルト), strict, none。none の場合は Secure を強制する
+func newSessionCookie() middleware.SessionCookie {
+	cookie := middleware.SessionCookie{
+		Secure: getEnvBool("SESSION_COOKIE_SECURE", false),
+		Domain: os.Getenv("SESSION_COOKIE_DOMAIN"),
+	}
+	switch v := getEnv("SESSION_COOKIE_SAMESITE", "lax"); strings.ToLower(v) {
+	case "lax":
+		cookie.SameSite = http.SameSiteLaxMode
+	case "strict":
+		cookie.SameSite = http.SameSiteStrictMode
+	case "none":
+		cookie.SameSite = http.SameSiteNoneMode
+		if !cookie.Secure {
+			log.Println("Warning: SESSION_COOKIE_SAMESITE=none requires Secure. Enabling SESSION_COOKIE_SECURE")
+			cookie.Secure = true
+		}
+	default:
+		log.Printf("Warning: SESSION_COOKIE_SAMESITE=%q is not one of lax, strict, none. Using lax", v)
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	return cookie
+}
+
 func (s *Server) setupRoutes(
 	authHandler *handler.AuthHandler,
 	productHandler *handler.ProductHandler,
 	orderHandler *handler.OrderHandler,
 	robotHandler *handler.RobotHandler,
+	categoryHandler *handler.CategoryHandler,
+	favoriteHandler *handler.FavoriteHandler,
+	recommendationHandler *handler.RecommendationHandler,
+	catalogHandler *handler.CatalogHandler,
+	webhookHandler *handler.WebhookHandler,
 	userAuthMW func(http.Handler) http.Handler,
+	csrfMW func(http.Handler) http.Handler,
 	robotAuthMW func(http.Handler) http.Handler,
+	adminAuthMW func(http.Handler) http.Handler,
 ) {
-	s.Router.Post("/api/login", authHandler.Login)
+	s.Router.With(csrfMW).Post("/api/login", authHandler.Login)
+	s.Router.With(csrfMW).Post("/api/logout", authHandler.Logout)
 
 	s.Router.Route("/api/v1", func(r chi.Router) {
+		r.Use(csrfMW)
 		r.Use(userAuthMW)
 		r.Post("/product", productHandler.List)
 		r.Post("/product/post", productHandler.CreateOrders)
 		r.Post("/orders", orderHandler.List)
+		r.Get("/orders/export", orderHandler.Export)
+		r.Get("/orders/quota", orderHandler.Quota)
+		r.Get("/orders/stats", orderHandler.Stats)
+		r.Get("/orders/{id}", orderHandler.Get)
+		r.Post("/orders/{id}/cancel", orderHandler.Cancel)
+		r.Post("/orders/{id}/reorder", productHandler.ReorderOrder)
+		r.Post("/checkouts/{id}/reorder", productHandler.ReorderCheckout)
 		r.Get("/image", productHandler.GetImage)
+		r.Get("/categories", categoryHandler.Tree)
+		r.Get("/tags", categoryHandler.Tags)
+		r.Get("/favorites", favoriteHandler.List)
+		r.Post("/favorites/{product_id}", favoriteHandler.Add)
+		r.Delete("/favorites/{product_id}", favoriteHandler.Remove)
+		r.Get("/recommendations/frequent", recommendationHandler.Frequent)
+		r.Get("/recommendations/co-ordered/{product_id}", recommendationHandler.CoOrdered)
+		r.Get("/webhooks", webhookHandler.List)
+		r.Post("/webhooks", webhookHandler.Create)
+		r.Delete("/webhooks/{id}", webhookHandler.Delete)
+		r.Get("/webhooks/{id}/deliveries", webhookHandler.Deliveries)
+		r.Get("/sessions", authHandler.ListSessions)
+		r.Delete("/sessions", authHandler.RevokeAllSessions)
+		r.Delete("/sessions/{id}", authHandler.RevokeSession)
 	})
 
 	s.Router.Route("/api/robot", func(r chi.Router) {
@@ -92,6 +285,16 @@ func (s *Server) setupRoutes(
 		r.Get("/delivery-plan", robotHandler.GetDeliveryPlan)
 		r.Patch("/orders/status", robotHandler.UpdateOrderStatus)
 	})
+
+	if adminAuthMW != nil {
+		s.Router.Route("/api/admin", func(r chi.Router) {
+			r.Use(adminAuthMW)
+			r.Post("/products/import", catalogHandler.Import)
+			r.Get("/products/export", catalogHandler.Export)
+			r.Get("/products/cache/stats", catalogHandler.CacheStats)
+			r.Get("/sessions/purge/stats", authHandler.SessionPurgeStats)
+		})
+	}
 }
 
 func (s *Server) Run() {
@@ -100,6 +303,12 @@ func (s *Server) Run() {
 		appPort = "8080"
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, j := range s.jobs {
+		j.Start(ctx)
+	}
+
 	log.Printf("Starting server on :%s", appPort)
 	if err := http.ListenAndServe(":"+appPort, s.Router); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
@@ -7,6 +7,7 @@ import (
 	"log"
 	"time"
 
+	"backend/internal/model"
 	"backend/internal/repository"
 	"backend/internal/service/utils"
 
@@ -18,17 +19,34 @@ var (
 	ErrUserNotFound    = errors.New("user not found")
 	ErrInvalidPassword = errors.New("invalid password")
 	ErrInternalServer  = errors.New("internal server error")
+	ErrSessionNotFound = errors.New("session not found")
 )
 
+type SessionConfig struct {
+	// ログイン・延長時の有効期間
+	TTL time.Duration
+	// 残りの有効期間がこれ以下になったリクエストで有効期限を延長する。0 以下の場合は延長しない
+	// 延長のための書き込みは最大で TTL - RenewBefore ごとに1回になる
+	RenewBefore time.Duration
+	// ログインからの最大の有効期間。延長してもこれを超えない
+	MaxLifetime time.Duration
+}
+
 type AuthService struct {
-	store *repository.Store
+	store        *repository.Store
+	sessionCache *SessionCache
+	config       SessionConfig
 }
 
-func NewAuthService(store *repository.Store) *AuthService {
-	return &AuthService{store: store}
+func NewAuthService(store *repository.Store, sessionCache *SessionCache, config SessionConfig) *AuthService {
+	config.MaxLifetime = max(config.MaxLifetime, config.TTL)
+	config.RenewBefore = min(config.RenewBefore, config.TTL)
+	return &AuthService{store: store, sessionCache: sessionCache, config: config}
 }
 
-func (s *AuthService) Login(ctx context.Context, userName, password string) (string, time.Time, error) {
+// ログインしてセッションを発行する
+// userAgent, ipAddress はセッション一覧での表示用に保存する
+func (s *AuthService) Login(ctx context.Context, userName, password, userAgent, ipAddress string) (string, time.Time, error) {
 	ctx, span := otel.Tracer("service.auth").Start(ctx, "AuthService.Login")
 	defer span.End()
 
@@ -51,8 +69,7 @@ func (s *AuthService) Login(ctx context.Context, userName, password string) (str
 			return ErrInvalidPassword
 		}
 
-		sessionDuration := 24 * time.Hour
-		sessionID, expiresAt, err = s.store.SessionRepo.Create(ctx, user.UserID, sessionDuration)
+		sessionID, expiresAt, err = s.store.SessionRepo.Create(ctx, user.UserID, s.config.TTL, userAgent, ipAddress)
 		if err != nil {
 			log.Printf("[Login] セッション生成失敗: %v", err)
 			return ErrInternalServer
@@ -65,3 +82,129 @@ func (s *AuthService) Login(ctx context.Context, userName, password string) (str
 	log.Printf("Login successful for UserName '%s', session created.", userName)
 	return sessionID, expiresAt, nil
 }
+
+// セッションIDからユーザーIDを取得する
+// 有効期限が近い場合は延長し、延長後の有効期限を返す。延長しなかった場合はゼロ値を返す
+func (s *AuthService) Authenticate(ctx context.Context, sessionID string) (int, time.Time, error) {
+	session, err := s.sessionCache.FindSession(ctx, sessionID)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	now := time.Now()
+	if s.config.RenewBefore <= 0 || session.ExpiresAt.Sub(now) > s.config.RenewBefore {
+		return session.UserID, time.Time{}, nil
+	}
+	expiresAt := now.Add(s.config.TTL)
+	if limit := session.CreatedAt.Add(s.config.MaxLifetime); expiresAt.After(limit) {
+		expiresAt = limit
+	}
+	if !expiresAt.After(session.ExpiresAt) {
+		return session.UserID, time.Time{}, nil
+	}
+
+	err = utils.WithTimeout(ctx, func(ctx context.Context) error {
+		renewed, err := s.store.SessionRepo.Renew(ctx, sessionID, expiresAt, now.Add(s.config.RenewBefore))
+		if err != nil || renewed {
+			return err
+		}
+		// 他のリクエスト(他のレプリカを含む)が延長済み。Cookieを合わせるため延長後の有効期限を読み直す
+		latest, err := s.store.SessionRepo.FindActiveSession(ctx, sessionID)
+		if err != nil {
+			return err
+		}
+		expiresAt = latest.ExpiresAt
+		return nil
+	})
+	if err != nil || !expiresAt.After(session.ExpiresAt) {
+		// 延長できなくても現在の有効期限までは有効なので、リクエストは通す
+		if err != nil {
+			log.Printf("Failed to renew session for user %d: %v", session.UserID, err)
+		}
+		return session.UserID, time.Time{}, nil
+	}
+	session.ExpiresAt = expiresAt
+	s.sessionCache.Update(session)
+	return session.UserID, expiresAt, nil
+}
+
+// セッションを削除してログアウトする
+// 存在しないセッションでもエラーにしない
+func (s *AuthService) Logout(ctx context.Context, sessionID string) error {
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			deleted, err := txStore.SessionRepo.Delete(ctx, sessionID)
+			if err != nil || !deleted {
+				return err
+			}
+			return txStore.SessionRepo.RecordRevocations(ctx, []string{sessionID}, time.Now())
+		})
+	})
+	if err != nil {
+		return err
+	}
+	s.sessionCache.Invalidate(sessionID)
+	return nil
+}
+
+// ユーザーの有効なセッション一覧を取得する
+// currentSessionID のセッションには Current を立てる
+func (s *AuthService) ListSessions(ctx context.Context, userID int, currentSessionID string) ([]model.Session, error) {
+	var sessions []model.Session
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		sessions, err = s.store.SessionRepo.ListByUser(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SessionID == currentSessionID
+	}
+	return sessions, nil
+}
+
+// ユーザーのセッションを1件失効させ、失効させたセッションのセッションIDを返す
+func (s *AuthService) RevokeSession(ctx context.Context, userID int, id int64) (string, error) {
+	var sessionID string
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			var err error
+			sessionID, err = txStore.SessionRepo.DeleteByID(ctx, userID, id)
+			if err != nil || sessionID == "" {
+				return err
+			}
+			return txStore.SessionRepo.RecordRevocations(ctx, []string{sessionID}, time.Now())
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	if sessionID == "" {
+		return "", ErrSessionNotFound
+	}
+	s.sessionCache.Invalidate(sessionID)
+	return sessionID, nil
+}
+
+// ユーザーの全セッションを失効させ、失効させた件数を返す
+// exceptSessionID を指定した場合、そのセッションは残す
+func (s *AuthService) RevokeAllSessions(ctx context.Context, userID int, exceptSessionID string) (int, error) {
+	var sessionIDs []string
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			var err error
+			sessionIDs, err = txStore.SessionRepo.DeleteAllByUser(ctx, userID, exceptSessionID)
+			if err != nil {
+				return err
+			}
+			return txStore.SessionRepo.RecordRevocations(ctx, sessionIDs, time.Now())
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.sessionCache.Invalidate(sessionIDs...)
+	return len(sessionIDs), nil
+}
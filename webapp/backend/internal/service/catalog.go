@@ -0,0 +1,454 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+var (
+	ErrUnsupportedCatalogFormat = errors.New("unsupported catalog format")
+	ErrInvalidCatalog           = errors.New("invalid catalog")
+)
+
+const (
+	maxCatalogImportRows = 100000
+	catalogBatchSize     = 500
+)
+
+var catalogColumns = []string{"product_id", "name", "value", "weight", "image", "description"}
+
+// JSON で出力する商品の項目
+// 一覧用の項目(お気に入りなど)を含めず、取り込みと同じ項目だけにする
+type catalogRecord struct {
+	ProductID   int    `json:"product_id"`
+	Name        string `json:"name"`
+	Value       int    `json:"value"`
+	Weight      int    `json:"weight"`
+	Image       string `json:"image"`
+	Description string `json:"description"`
+}
+
+type CatalogService struct {
+	store        *repository.Store
+	productCache *ProductCache
+}
+
+// productCache が nil の場合は更新後のキャッシュ破棄を行わない
+func NewCatalogService(store *repository.Store, productCache *ProductCache) *CatalogService {
+	return &CatalogService{store: store, productCache: productCache}
+}
+
+// インポート1行分の入力
+// 省略された項目を区別するためポインタで持つ
+type productRecord struct {
+	ProductID   *int    `json:"product_id"`
+	Name        *string `json:"name"`
+	Value       *int    `json:"value"`
+	Weight      *int    `json:"weight"`
+	Image       *string `json:"image"`
+	Description *string `json:"description"`
+
+	// 型変換に失敗した項目。検証時に重複してエラーを出さないために使う
+	invalid map[string]bool
+}
+
+func (rec *productRecord) markInvalid(field string) {
+	if rec.invalid == nil {
+		rec.invalid = make(map[string]bool)
+	}
+	rec.invalid[field] = true
+}
+
+// CSV または JSON の商品カタログを検証し、product_id をキーに登録・更新する
+// dryRun の場合や1件でもエラーがある場合は何も書き込まず、差分のみを返す
+func (s *CatalogService) Import(ctx context.Context, r io.Reader, format string, dryRun bool) (*model.ProductImportReport, error) {
+	var records []productRecord
+	var importErrs []model.ProductImportError
+	var err error
+	switch format {
+	case "csv":
+		records, importErrs, err = parseCatalogCSV(r)
+	case "json":
+		records, importErrs, err = parseCatalogJSON(r)
+	default:
+		return nil, ErrUnsupportedCatalogFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := &model.ProductImportReport{
+		DryRun:  dryRun,
+		Changes: []model.ProductChange{},
+		Errors:  append([]model.ProductImportError{}, importErrs...),
+	}
+
+	products, errs := validateProductRecords(records)
+	report.Errors = append(report.Errors, errs...)
+
+	existing, err := s.findExisting(ctx, products)
+	if err != nil {
+		return nil, err
+	}
+
+	toWrite := make([]model.Product, 0, len(products))
+	for i, rec := range records {
+		p := products[i]
+		if p == nil {
+			continue
+		}
+		change := model.ProductChange{Row: i + 1, ProductID: p.ProductID}
+		old, ok := existing[p.ProductID]
+		if !ok {
+			change.Action = "create"
+			report.Created++
+		} else {
+			// 省略された任意項目は既存の値を引き継ぐ
+			if rec.Image == nil {
+				p.Image = old.Image
+			}
+			if rec.Description == nil {
+				p.Description = old.Description
+			}
+			change.Fields = diffProduct(old, *p)
+			if len(change.Fields) == 0 {
+				change.Action = "unchanged"
+				report.Unchanged++
+				report.Changes = append(report.Changes, change)
+				continue
+			}
+			change.Action = "update"
+			report.Updated++
+		}
+		report.Changes = append(report.Changes, change)
+		toWrite = append(toWrite, *p)
+	}
+
+	if dryRun || len(report.Errors) > 0 {
+		return report, nil
+	}
+
+	err = s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		for start := 0; start < len(toWrite); start += catalogBatchSize {
+			end := min(start+catalogBatchSize, len(toWrite))
+			if err := txStore.ProductRepo.UpsertBulk(ctx, toWrite[start:end]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.productCache.Invalidate()
+	report.Applied = true
+	log.Printf("Imported product catalog: created=%d updated=%d unchanged=%d", report.Created, report.Updated, report.Unchanged)
+	return report, nil
+}
+
+// 全商品を CSV または JSON で w に書き出す
+func (s *CatalogService) Export(ctx context.Context, w io.Writer, format string) error {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(catalogColumns); err != nil {
+			return err
+		}
+		err := s.store.ProductRepo.ForEach(ctx, func(p model.Product) error {
+			return cw.Write([]string{
+				strconv.Itoa(p.ProductID),
+				p.Name,
+				strconv.Itoa(p.Value),
+				strconv.Itoa(p.Weight),
+				p.Image,
+				p.Description,
+			})
+		})
+		if err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		if _, err := io.WriteString(w, "["); err != nil {
+			return err
+		}
+		enc := json.NewEncoder(w)
+		first := true
+		err := s.store.ProductRepo.ForEach(ctx, func(p model.Product) error {
+			if !first {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			first = false
+			return enc.Encode(catalogRecord{
+				ProductID:   p.ProductID,
+				Name:        p.Name,
+				Value:       p.Value,
+				Weight:      p.Weight,
+				Image:       p.Image,
+				Description: p.Description,
+			})
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, "]\n")
+		return err
+	default:
+		return ErrUnsupportedCatalogFormat
+	}
+}
+
+func (s *CatalogService) findExisting(ctx context.Context, products []*model.Product) (map[int]model.Product, error) {
+	ids := make([]int, 0, len(products))
+	for _, p := range products {
+		if p != nil && p.ProductID > 0 {
+			ids = append(ids, p.ProductID)
+		}
+	}
+
+	existing := make(map[int]model.Product, len(ids))
+	for start := 0; start < len(ids); start += catalogBatchSize {
+		end := min(start+catalogBatchSize, len(ids))
+		found, err := s.store.ProductRepo.FindByIDs(ctx, ids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range found {
+			existing[p.ProductID] = p
+		}
+	}
+	return existing, nil
+}
+
+func parseCatalogCSV(r io.Reader) ([]productRecord, []model.ProductImportError, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to read csv header: %v", ErrInvalidCatalog, err)
+	}
+
+	columns := make([]string, len(header))
+	seen := make(map[string]bool, len(header))
+	for i, h := range header {
+		name := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if !isCatalogColumn(name) {
+			return nil, nil, fmt.Errorf("%w: unknown column %q", ErrInvalidCatalog, h)
+		}
+		if seen[name] {
+			return nil, nil, fmt.Errorf("%w: duplicated column %q", ErrInvalidCatalog, h)
+		}
+		seen[name] = true
+		columns[i] = name
+	}
+	for _, required := range []string{"name", "value", "weight"} {
+		if !seen[required] {
+			return nil, nil, fmt.Errorf("%w: missing column %q", ErrInvalidCatalog, required)
+		}
+	}
+
+	var records []productRecord
+	var errs []model.ProductImportError
+	for {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidCatalog, err)
+		}
+		if len(records) >= maxCatalogImportRows {
+			return nil, nil, fmt.Errorf("%w: too many rows (max %d)", ErrInvalidCatalog, maxCatalogImportRows)
+		}
+
+		row := len(records) + 1
+		var rec productRecord
+		for i, name := range columns {
+			v := fields[i]
+			switch name {
+			case "product_id":
+				if v == "" {
+					continue
+				}
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					errs = append(errs, model.ProductImportError{Row: row, Field: name, Message: "must be an integer"})
+					rec.markInvalid(name)
+					continue
+				}
+				rec.ProductID = &n
+			case "value", "weight":
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					errs = append(errs, model.ProductImportError{Row: row, Field: name, Message: "must be an integer"})
+					rec.markInvalid(name)
+					continue
+				}
+				if name == "value" {
+					rec.Value = &n
+				} else {
+					rec.Weight = &n
+				}
+			case "name":
+				rec.Name = &v
+			case "image":
+				rec.Image = &v
+			case "description":
+				rec.Description = &v
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, errs, nil
+}
+
+func parseCatalogJSON(r io.Reader) ([]productRecord, []model.ProductImportError, error) {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidCatalog, err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, nil, fmt.Errorf("%w: expected a JSON array", ErrInvalidCatalog)
+	}
+
+	var records []productRecord
+	var errs []model.ProductImportError
+	for dec.More() {
+		if len(records) >= maxCatalogImportRows {
+			return nil, nil, fmt.Errorf("%w: too many rows (max %d)", ErrInvalidCatalog, maxCatalogImportRows)
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidCatalog, err)
+		}
+		var rec productRecord
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			errs = append(errs, model.ProductImportError{Row: len(records) + 1, Message: err.Error()})
+			rec.markInvalid("*")
+		}
+		records = append(records, rec)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidCatalog, err)
+	}
+	return records, errs, nil
+}
+
+// 入力値を検証する
+// 返すスライスは records と同じ長さで、エラーのある行は nil になる
+func validateProductRecords(records []productRecord) ([]*model.Product, []model.ProductImportError) {
+	products := make([]*model.Product, len(records))
+	var errs []model.ProductImportError
+	seen := make(map[int]int)
+
+	for i, rec := range records {
+		if rec.invalid["*"] {
+			continue
+		}
+		row := i + 1
+		// 型変換エラーは解析時に記録済みなので、その行は登録対象にしない
+		valid := len(rec.invalid) == 0
+		addErr := func(field, msg string) {
+			valid = false
+			errs = append(errs, model.ProductImportError{Row: row, Field: field, Message: msg})
+		}
+
+		p := model.Product{}
+		if rec.ProductID != nil {
+			if *rec.ProductID <= 0 {
+				addErr("product_id", "must be positive")
+			} else if prev, ok := seen[*rec.ProductID]; ok {
+				addErr("product_id", fmt.Sprintf("duplicated with row %d", prev))
+			} else {
+				seen[*rec.ProductID] = row
+				p.ProductID = *rec.ProductID
+			}
+		}
+
+		if rec.Name == nil || strings.TrimSpace(*rec.Name) == "" {
+			addErr("name", "is required")
+		} else if utf8.RuneCountInString(*rec.Name) > 255 {
+			addErr("name", "must be at most 255 characters")
+		} else {
+			p.Name = *rec.Name
+		}
+
+		if rec.Value == nil {
+			if !rec.invalid["value"] {
+				addErr("value", "is required")
+			}
+		} else if *rec.Value < 0 {
+			addErr("value", "must not be negative")
+		} else {
+			p.Value = *rec.Value
+		}
+
+		if rec.Weight == nil {
+			if !rec.invalid["weight"] {
+				addErr("weight", "is required")
+			}
+		} else if *rec.Weight < 0 {
+			addErr("weight", "must not be negative")
+		} else {
+			p.Weight = *rec.Weight
+		}
+
+		if rec.Image != nil {
+			if utf8.RuneCountInString(*rec.Image) > 500 {
+				addErr("image", "must be at most 500 characters")
+			}
+			p.Image = *rec.Image
+		}
+		if rec.Description != nil {
+			p.Description = *rec.Description
+		}
+
+		if valid {
+			products[i] = &p
+		}
+	}
+	return products, errs
+}
+
+func diffProduct(old, updated model.Product) []string {
+	var fields []string
+	if old.Name != updated.Name {
+		fields = append(fields, "name")
+	}
+	if old.Value != updated.Value {
+		fields = append(fields, "value")
+	}
+	if old.Weight != updated.Weight {
+		fields = append(fields, "weight")
+	}
+	if old.Image != updated.Image {
+		fields = append(fields, "image")
+	}
+	if old.Description != updated.Description {
+		fields = append(fields, "description")
+	}
+	return fields
+}
+
+func isCatalogColumn(name string) bool {
+	for _, c := range catalogColumns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
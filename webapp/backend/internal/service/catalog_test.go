@@ -0,0 +1,123 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func TestValidateProductRecords(t *testing.T) {
+	valid := productRecord{
+		Name:   ptr("りんご"),
+		Value:  ptr(100),
+		Weight: ptr(200),
+	}
+	withID := func(rec productRecord, id int) productRecord {
+		rec.ProductID = ptr(id)
+		return rec
+	}
+
+	tests := []struct {
+		name        string
+		records     []productRecord
+		wantValid   []bool
+		wantErrors  []model.ProductImportError
+		wantProduct *model.Product
+	}{
+		{
+			name:        "valid record",
+			records:     []productRecord{withID(valid, 1)},
+			wantValid:   []bool{true},
+			wantProduct: &model.Product{ProductID: 1, Name: "りんご", Value: 100, Weight: 200},
+		},
+		{
+			name:        "new product without id",
+			records:     []productRecord{valid},
+			wantValid:   []bool{true},
+			wantProduct: &model.Product{Name: "りんご", Value: 100, Weight: 200},
+		},
+		{
+			name:      "missing required fields",
+			records:   []productRecord{{}},
+			wantValid: []bool{false},
+			wantErrors: []model.ProductImportError{
+				{Row: 1, Field: "name", Message: "is required"},
+				{Row: 1, Field: "value", Message: "is required"},
+				{Row: 1, Field: "weight", Message: "is required"},
+			},
+		},
+		{
+			name:      "blank name and negative numbers",
+			records:   []productRecord{{Name: ptr("  "), Value: ptr(-1), Weight: ptr(-1)}},
+			wantValid: []bool{false},
+			wantErrors: []model.ProductImportError{
+				{Row: 1, Field: "name", Message: "is required"},
+				{Row: 1, Field: "value", Message: "must not be negative"},
+				{Row: 1, Field: "weight", Message: "must not be negative"},
+			},
+		},
+		{
+			name:      "non-positive id",
+			records:   []productRecord{withID(valid, 0)},
+			wantValid: []bool{false},
+			wantErrors: []model.ProductImportError{
+				{Row: 1, Field: "product_id", Message: "must be positive"},
+			},
+		},
+		{
+			name:      "duplicated id",
+			records:   []productRecord{withID(valid, 5), withID(valid, 5)},
+			wantValid: []bool{true, false},
+			wantErrors: []model.ProductImportError{
+				{Row: 2, Field: "product_id", Message: "duplicated with row 1"},
+			},
+		},
+		{
+			name: "too long name and image",
+			records: []productRecord{{
+				Name:   ptr(strings.Repeat("あ", 256)),
+				Value:  ptr(1),
+				Weight: ptr(1),
+				Image:  ptr(strings.Repeat("a", 501)),
+			}},
+			wantValid: []bool{false},
+			wantErrors: []model.ProductImportError{
+				{Row: 1, Field: "name", Message: "must be at most 255 characters"},
+				{Row: 1, Field: "image", Message: "must be at most 500 characters"},
+			},
+		},
+		{
+			name:      "type error reported while parsing",
+			records:   []productRecord{{Name: ptr("x"), Weight: ptr(1), invalid: map[string]bool{"value": true}}},
+			wantValid: []bool{false},
+		},
+		{
+			name:      "unparseable row",
+			records:   []productRecord{{invalid: map[string]bool{"*": true}}},
+			wantValid: []bool{false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			products, errs := validateProductRecords(tt.records)
+			if len(products) != len(tt.records) {
+				t.Fatalf("len(products) = %d, want %d", len(products), len(tt.records))
+			}
+			for i, want := range tt.wantValid {
+				if got := products[i] != nil; got != want {
+					t.Errorf("products[%d] valid = %t, want %t", i, got, want)
+				}
+			}
+			if !reflect.DeepEqual(errs, tt.wantErrors) {
+				t.Errorf("errors = %+v, want %+v", errs, tt.wantErrors)
+			}
+			if tt.wantProduct != nil && !reflect.DeepEqual(products[0], tt.wantProduct) {
+				t.Errorf("products[0] = %+v, want %+v", products[0], tt.wantProduct)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+	"context"
+)
+
+type CategoryService struct {
+	store *repository.Store
+}
+
+func NewCategoryService(store *repository.Store) *CategoryService {
+	return &CategoryService{store: store}
+}
+
+// カテゴリを階層構造で取得する
+func (s *CategoryService) GetTree(ctx context.Context) ([]*model.Category, error) {
+	var roots []*model.Category
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		categories, err := s.store.CategoryRepo.ListAll(ctx)
+		if err != nil {
+			return err
+		}
+		roots = newCategoryIndex(categories).roots
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return roots, nil
+}
+
+func (s *CategoryService) ListTags(ctx context.Context) ([]model.Tag, error) {
+	var tags []model.Tag
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		tags, err = s.store.TagRepo.ListWithCounts(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// カテゴリをIDから引けるようにし、親子関係を組み立てたもの
+type categoryIndex struct {
+	byID  map[int]*model.Category
+	roots []*model.Category
+}
+
+func newCategoryIndex(categories []model.Category) *categoryIndex {
+	idx := &categoryIndex{
+		byID:  make(map[int]*model.Category, len(categories)),
+		roots: []*model.Category{},
+	}
+	for i := range categories {
+		c := &categories[i]
+		c.Children = []*model.Category{}
+		idx.byID[c.CategoryID] = c
+	}
+	for i := range categories {
+		c := &categories[i]
+		if c.ParentID != nil {
+			if parent, ok := idx.byID[*c.ParentID]; ok {
+				parent.Children = append(parent.Children, c)
+				continue
+			}
+		}
+		idx.roots = append(idx.roots, c)
+	}
+	return idx
+}
+
+// 指定したカテゴリとその配下すべてのカテゴリIDを返す
+func (idx *categoryIndex) descendantIDs(categoryID int) []int {
+	root, ok := idx.byID[categoryID]
+	if !ok {
+		return nil
+	}
+	var ids []int
+	visited := make(map[int]bool)
+	stack := []*model.Category{root}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[c.CategoryID] {
+			continue
+		}
+		visited[c.CategoryID] = true
+		ids = append(ids, c.CategoryID)
+		stack = append(stack, c.Children...)
+	}
+	return ids
+}
+
+// ルートから指定したカテゴリまでのパスを返す
+func (idx *categoryIndex) breadcrumb(categoryID int) []model.CategoryRef {
+	var path []model.CategoryRef
+	visited := make(map[int]bool)
+	for c, ok := idx.byID[categoryID]; ok && !visited[c.CategoryID]; {
+		visited[c.CategoryID] = true
+		path = append(path, model.CategoryRef{CategoryID: c.CategoryID, Name: c.Name})
+		if c.ParentID == nil {
+			break
+		}
+		c, ok = idx.byID[*c.ParentID]
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"backend/internal/model"
+)
+
+func testCategories() []model.Category {
+	return []model.Category{
+		{CategoryID: 1, Name: "食品"},
+		{CategoryID: 2, ParentID: ptr(1), Name: "果物"},
+		{CategoryID: 3, ParentID: ptr(2), Name: "りんご"},
+		{CategoryID: 4, ParentID: ptr(1), Name: "野菜"},
+		{CategoryID: 5, Name: "日用品"},
+		// 存在しない親を指すカテゴリはルートとして扱う
+		{CategoryID: 6, ParentID: ptr(99), Name: "孤児"},
+		// 親子関係が循環しているカテゴリ
+		{CategoryID: 7, ParentID: ptr(8), Name: "循環A"},
+		{CategoryID: 8, ParentID: ptr(7), Name: "循環B"},
+	}
+}
+
+func TestNewCategoryIndexRoots(t *testing.T) {
+	idx := newCategoryIndex(testCategories())
+	var roots []int
+	for _, c := range idx.roots {
+		roots = append(roots, c.CategoryID)
+	}
+	if want := []int{1, 5, 6}; !reflect.DeepEqual(roots, want) {
+		t.Errorf("roots = %v, want %v", roots, want)
+	}
+}
+
+func TestCategoryIndexDescendantIDs(t *testing.T) {
+	idx := newCategoryIndex(testCategories())
+	tests := []struct {
+		name       string
+		categoryID int
+		want       []int
+	}{
+		{"root", 1, []int{1, 2, 3, 4}},
+		{"middle", 2, []int{2, 3}},
+		{"leaf", 3, []int{3}},
+		{"orphan", 6, []int{6}},
+		{"cycle", 7, []int{7, 8}},
+		{"unknown", 99, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := idx.descendantIDs(tt.categoryID)
+			slices.Sort(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("descendantIDs(%d) = %v, want %v", tt.categoryID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCategoryIndexBreadcrumb(t *testing.T) {
+	idx := newCategoryIndex(testCategories())
+	tests := []struct {
+		name       string
+		categoryID int
+		want       []model.CategoryRef
+	}{
+		{"root", 1, []model.CategoryRef{{CategoryID: 1, Name: "食品"}}},
+		{"leaf", 3, []model.CategoryRef{
+			{CategoryID: 1, Name: "食品"},
+			{CategoryID: 2, Name: "果物"},
+			{CategoryID: 3, Name: "りんご"},
+		}},
+		{"orphan", 6, []model.CategoryRef{{CategoryID: 6, Name: "孤児"}}},
+		{"cycle", 7, []model.CategoryRef{
+			{CategoryID: 8, Name: "循環B"},
+			{CategoryID: 7, Name: "循環A"},
+		}},
+		{"unknown", 99, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := idx.breadcrumb(tt.categoryID); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("breadcrumb(%d) = %+v, want %+v", tt.categoryID, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+	"context"
+	"errors"
+)
+
+var ErrProductNotFound = errors.New("product not found")
+
+type FavoriteService struct {
+	store *repository.Store
+}
+
+func NewFavoriteService(store *repository.Store) *FavoriteService {
+	return &FavoriteService{store: store}
+}
+
+// お気に入りに追加する
+// 新しく追加した場合は true、既に追加済みの場合は false を返す
+func (s *FavoriteService) Add(ctx context.Context, userID, productID int) (bool, error) {
+	var created bool
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		products, err := s.store.ProductRepo.FindByIDs(ctx, []int{productID})
+		if err != nil {
+			return err
+		}
+		if len(products) == 0 {
+			return ErrProductNotFound
+		}
+		created, err = s.store.FavoriteRepo.Add(ctx, userID, productID)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+func (s *FavoriteService) Remove(ctx context.Context, userID, productID int) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.FavoriteRepo.Remove(ctx, userID, productID)
+	})
+}
+
+// お気に入りの商品一覧を取得する
+func (s *FavoriteService) List(ctx context.Context, userID, page, pageSize int) ([]model.Product, int, error) {
+	var products []model.Product
+	var total int
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		products, total, err = s.store.FavoriteRepo.ListProducts(ctx, userID, pageSize, (page-1)*pageSize)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range products {
+		products[i].IsFavorite = true
+	}
+	return products, total, nil
+}
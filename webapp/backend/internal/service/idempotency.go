@@ -0,0 +1,106 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/repository"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var ErrIdempotencyKeyMismatch = errors.New("idempotency key was used with a different request")
+
+// 期限切れのキーを一度に削除する件数
+const idempotencyPurgeBatchSize = 1000
+
+// 冪等キーとレスポンスを保存し、同じキーでの再送に保存済みのレスポンスを返す
+type IdempotencyService struct {
+	store *repository.Store
+	// キーを保持する期間
+	ttl time.Duration
+}
+
+func NewIdempotencyService(store *repository.Store, ttl time.Duration) *IdempotencyService {
+	return &IdempotencyService{store: store, ttl: ttl}
+}
+
+// トランザクション内でキーを予約する
+// 既に処理済みのキーであれば保存済みのレスポンスを返す
+// 同じキーを処理中の別リクエストがある場合は、そのトランザクションが終わるまで待つ
+func (s *IdempotencyService) reserve(ctx context.Context, txStore *repository.Store, userID int, key, requestHash string) (*model.IdempotencyRecord, error) {
+	now := time.Now()
+	inserted, err := txStore.IdempotencyRepo.Insert(ctx, userID, key, requestHash, now.Add(s.ttl))
+	if err != nil {
+		return nil, err
+	}
+	if inserted {
+		return nil, nil
+	}
+
+	rec, err := txStore.IdempotencyRepo.FindForUpdate(ctx, userID, key)
+	if err != nil {
+		return nil, err
+	}
+	// 期限切れ、または待っている間に削除された場合は新しいキーとして扱う
+	if rec == nil || !rec.ExpiresAt.After(now) {
+		if err := txStore.IdempotencyRepo.Delete(ctx, userID, key); err != nil {
+			return nil, err
+		}
+		if _, err := txStore.IdempotencyRepo.Insert(ctx, userID, key, requestHash, now.Add(s.ttl)); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if rec.RequestHash != requestHash {
+		return nil, ErrIdempotencyKeyMismatch
+	}
+	if rec.ResponseBody == nil {
+		// レスポンスは予約と同じトランザクションで保存するため、通常ここには来ない
+		return nil, fmt.Errorf("idempotency key %q has no stored response", key)
+	}
+	return rec, nil
+}
+
+func (s *IdempotencyService) save(ctx context.Context, txStore *repository.Store, userID int, key string, response any) error {
+	body, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return txStore.IdempotencyRepo.SaveResponse(ctx, userID, key, body)
+}
+
+// 期限切れのキーを削除する
+// 定期ジョブから呼び出す。ロックを長く持たないよう少しずつ削除する
+func (s *IdempotencyService) PurgeExpired(ctx context.Context) error {
+	var total int64
+	for {
+		n, err := s.store.IdempotencyRepo.DeleteExpired(ctx, time.Now(), idempotencyPurgeBatchSize)
+		if err != nil {
+			return err
+		}
+		total += n
+		if n < idempotencyPurgeBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("Purged %d expired idempotency keys", total)
+	}
+	return nil
+}
+
+// リクエストの内容を比較するためのハッシュ
+// 空白やキーの順序の違いで別のリクエストと判定しないよう、デコード後の値から求める
+func idempotencyRequestHash(request any) (string, error) {
+	b, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,364 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "image/gif"
+
+	"backend/internal/cache"
+	"backend/internal/service/utils"
+	"backend/internal/storage"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	ErrImageNotFound          = errors.New("image not found")
+	ErrInvalidImagePath       = errors.New("invalid image path")
+	ErrUnsupportedImageSize   = errors.New("unsupported image size")
+	ErrUnsupportedImageFormat = errors.New("unsupported image format")
+	ErrImageTooLarge          = errors.New("image is too large to resize")
+)
+
+const (
+	jpegQuality = 85
+	// リサイズのためにデコードする元画像の最大ピクセル数。デコード後のメモリを抑えるために制限する
+	maxImagePixels = 40_000_000
+)
+
+// 画像取得時の変換オプション
+// Width/Height が 0 の場合はその辺を元画像の縦横比に合わせる
+// Format が空の場合は元画像の形式のまま返す
+type ImageOptions struct {
+	Width  int
+	Height int
+	Format string
+}
+
+type Image struct {
+	Data        []byte
+	ContentType string
+	ModTime     time.Time
+	ETag        string
+}
+
+type ImageService struct {
+	store        storage.ImageStore
+	allowedSizes map[int]bool
+	cache        *imageDiskCache
+	memCache     *cache.LRU[string, *Image]
+	// 同じ画像の同時リクエストでリサイズを1回にまとめる
+	group singleflight.Group
+	// 0 より大きい場合、保存先が対応していれば署名付きURLへのリダイレクトを返す
+	signedURLExpiry time.Duration
+}
+
+func NewImageService(store storage.ImageStore, cacheDir string, cacheMaxBytes, memCacheMaxBytes int64, allowedSizes []int, signedURLExpiry time.Duration) (*ImageService, error) {
+	diskCache, err := newImageDiskCache(cacheDir, cacheMaxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize image cache: %w", err)
+	}
+	sizes := make(map[int]bool, len(allowedSizes))
+	for _, s := range allowedSizes {
+		sizes[s] = true
+	}
+	// 元画像の更新日時をキーに含めるため、メモリキャッシュは期限切れにしない
+	memCache := cache.NewLRUWithCost[string](memCacheMaxBytes, 0, func(img *Image) int64 {
+		return int64(len(img.Data))
+	})
+	return &ImageService{
+		store:           store,
+		allowedSizes:    sizes,
+		cache:           diskCache,
+		memCache:        memCache,
+		signedURLExpiry: signedURLExpiry,
+	}, nil
+}
+
+// 画像を直接取得できる署名付きURLを返す
+// 変換が必要な場合や保存先が署名付きURLに対応していない場合は ok=false を返す
+func (s *ImageService) SignedURL(ctx context.Context, imagePath string, opts ImageOptions) (string, bool, error) {
+	signer, ok := s.store.(storage.URLSigner)
+	if !ok || s.signedURLExpiry <= 0 {
+		return "", false, nil
+	}
+	if opts.Width != 0 || opts.Height != 0 || opts.Format != "" {
+		return "", false, nil
+	}
+	imagePath, err := cleanImagePath(imagePath)
+	if err != nil {
+		return "", false, err
+	}
+	if _, err := s.store.Stat(ctx, imagePath); err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			return "", false, ErrImageNotFound
+		}
+		return "", false, err
+	}
+	u, err := signer.SignedURL(ctx, imagePath, s.signedURLExpiry)
+	if errors.Is(err, storage.ErrSignedURLNotSupported) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return u, true, nil
+}
+
+// 画像を取得し、必要であればリサイズ・再エンコードして返す
+func (s *ImageService) GetImage(ctx context.Context, imagePath string, opts ImageOptions) (*Image, error) {
+	imagePath, err := cleanImagePath(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validateOptions(&opts); err != nil {
+		return nil, err
+	}
+
+	info, err := s.store.Stat(ctx, imagePath)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			return nil, ErrImageNotFound
+		}
+		return nil, err
+	}
+
+	// 元画像が更新された場合に古いキャッシュを使わないよう、更新日時もキーに含める
+	key := imageCacheKey(imagePath, info.ModTime, opts.Width, opts.Height, opts.Format)
+	if img, ok := s.memCache.Get(key); ok {
+		return img, nil
+	}
+
+	var data []byte
+	var contentType string
+	if opts.Width == 0 && opts.Height == 0 && opts.Format == "" {
+		// 変換不要ならオリジナルをそのまま返す
+		data, err = s.readAll(ctx, imagePath)
+		if err != nil {
+			return nil, err
+		}
+		contentType = contentTypeByExt(imagePath)
+	} else {
+		format := opts.Format
+		if format == "" {
+			format = formatByExt(imagePath)
+		}
+		contentType = "image/" + format
+
+		data, err = s.resized(ctx, key, imagePath, opts.Width, opts.Height, format)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	img := &Image{
+		Data:        data,
+		ContentType: contentType,
+		ModTime:     info.ModTime,
+		ETag:        imageETag(data),
+	}
+	s.memCache.Set(key, img)
+	return img, nil
+}
+
+// リサイズ済みの画像をディスクキャッシュから取得し、無ければリサイズして保存する
+// 最初のリクエストがキャンセルされても、相乗りしている他のリクエストは失敗させない
+func (s *ImageService) resized(ctx context.Context, key, imagePath string, width, height int, format string) ([]byte, error) {
+	if data, ok := s.cache.get(key); ok {
+		return data, nil
+	}
+	ch := s.group.DoChan(key, func() (interface{}, error) {
+		var data []byte
+		err := utils.WithTimeout(context.WithoutCancel(ctx), func(ctx context.Context) error {
+			var err error
+			data, err = s.resize(ctx, imagePath, width, height, format)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.cache.put(key, data)
+		return data, nil
+	})
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return r.Val.([]byte), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *ImageService) validateOptions(opts *ImageOptions) error {
+	if opts.Width < 0 || opts.Height < 0 {
+		return ErrUnsupportedImageSize
+	}
+	if opts.Width != 0 && !s.allowedSizes[opts.Width] {
+		return ErrUnsupportedImageSize
+	}
+	if opts.Height != 0 && !s.allowedSizes[opts.Height] {
+		return ErrUnsupportedImageSize
+	}
+
+	switch strings.ToLower(opts.Format) {
+	case "":
+	case "jpg", "jpeg":
+		opts.Format = "jpeg"
+	case "png":
+		opts.Format = "png"
+	default:
+		return ErrUnsupportedImageFormat
+	}
+	return nil
+}
+
+func (s *ImageService) readAll(ctx context.Context, imagePath string) ([]byte, error) {
+	rc, err := s.store.Open(ctx, imagePath)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			return nil, ErrImageNotFound
+		}
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func (s *ImageService) resize(ctx context.Context, imagePath string, width, height int, format string) ([]byte, error) {
+	rc, err := s.store.Open(ctx, imagePath)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			return nil, ErrImageNotFound
+		}
+		return nil, err
+	}
+	defer rc.Close()
+
+	// ヘッダーだけを読んでサイズを確認し、読んだ分は本体のデコードに使う
+	var header bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(rc, &header))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image %s: %w", imagePath, err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+		return nil, fmt.Errorf("%w: %s is %dx%d", ErrImageTooLarge, imagePath, cfg.Width, cfg.Height)
+	}
+
+	src, _, err := image.Decode(io.MultiReader(&header, rc))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image %s: %w", imagePath, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	dstW, dstH := fitSize(src.Bounds().Dx(), src.Bounds().Dy(), width, height)
+	var dst draw.Image
+	if format == "jpeg" {
+		dst = image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+		// JPEGは透過を持てないので白背景にする
+		draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Over, nil)
+	} else {
+		dst = image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+	case "png":
+		err = png.Encode(&buf, dst)
+	default:
+		return nil, ErrUnsupportedImageFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 縦横比を保ったまま width x height に収まるサイズを求める
+// 元画像より大きくはしない
+func fitSize(srcW, srcH, width, height int) (int, int) {
+	if width == 0 && height == 0 {
+		return srcW, srcH
+	}
+	scale := 1.0
+	if width > 0 {
+		scale = float64(width) / float64(srcW)
+	}
+	if height > 0 {
+		if hs := float64(height) / float64(srcH); width == 0 || hs < scale {
+			scale = hs
+		}
+	}
+	if scale > 1 {
+		scale = 1
+	}
+	dstW := max(int(float64(srcW)*scale+0.5), 1)
+	dstH := max(int(float64(srcH)*scale+0.5), 1)
+	return dstW, dstH
+}
+
+// ディレクトリトラバーサルを防ぎ、保存先に渡す "/" 区切りのパスに正規化する
+func cleanImagePath(imagePath string) (string, error) {
+	imagePath = filepath.Clean(imagePath)
+	if filepath.IsAbs(imagePath) || strings.Contains(imagePath, "..") {
+		return "", ErrInvalidImagePath
+	}
+	return filepath.ToSlash(imagePath), nil
+}
+
+func imageCacheKey(imagePath string, modTime time.Time, width, height int, format string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%d|%s", imagePath, modTime.UnixNano(), width, height, format)))
+	return hex.EncodeToString(sum[:])
+}
+
+func imageETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func contentTypeByExt(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// 再エンコード先の形式を元画像の拡張子から決める
+// JPEG以外は透過を保てるPNGにする
+func formatByExt(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		return "jpeg"
+	default:
+		return "png"
+	}
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// リサイズ済み画像をディスクに保存するキャッシュ
+// 合計サイズが maxBytes を超えたら最終アクセスの古いものから削除する
+type imageDiskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*imageCacheEntry
+	total   int64
+}
+
+type imageCacheEntry struct {
+	size       int64
+	lastAccess time.Time
+}
+
+func newImageDiskCache(dir string, maxBytes int64) (*imageDiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &imageDiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*imageCacheEntry),
+	}
+
+	// 再起動前に作られたキャッシュファイルも管理対象にする
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(f.Name(), ".tmp-") {
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		c.entries[f.Name()] = &imageCacheEntry{size: info.Size(), lastAccess: info.ModTime()}
+		c.total += info.Size()
+	}
+	c.mu.Lock()
+	c.evictLocked()
+	c.mu.Unlock()
+	return c, nil
+}
+
+func (c *imageDiskCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok {
+		entry.lastAccess = time.Now()
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(filepath.Join(c.dir, key))
+	if err != nil {
+		c.remove(key)
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *imageDiskCache) put(key string, data []byte) {
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return
+	}
+
+	// 書きかけのファイルを読まれないよう一時ファイル経由で置き換える
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		log.Printf("画像キャッシュの作成に失敗: %v", err)
+		return
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		log.Printf("画像キャッシュの書き込みに失敗: %v", err)
+		return
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, key)); err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("画像キャッシュの保存に失敗: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[key]; ok {
+		c.total -= old.size
+	}
+	c.entries[key] = &imageCacheEntry{size: size, lastAccess: time.Now()}
+	c.total += size
+	c.evictLocked()
+}
+
+func (c *imageDiskCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		c.total -= entry.size
+		delete(c.entries, key)
+	}
+}
+
+func (c *imageDiskCache) evictLocked() {
+	if c.total <= c.maxBytes {
+		return
+	}
+
+	keys := make([]string, 0, len(c.entries))
+	for k := range c.entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].lastAccess.Before(c.entries[keys[j]].lastAccess)
+	})
+
+	for _, k := range keys {
+		if c.total <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, k)); err != nil && !os.IsNotExist(err) {
+			log.Printf("画像キャッシュの削除に失敗: %v", err)
+			continue
+		}
+		c.total -= c.entries[k].size
+		delete(c.entries, k)
+	}
+}
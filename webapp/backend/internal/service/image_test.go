@@ -0,0 +1,184 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"backend/internal/storage"
+)
+
+func TestFitSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		srcW, srcH    int
+		width, height int
+		wantW, wantH  int
+	}{
+		{"no resize", 800, 600, 0, 0, 800, 600},
+		{"width only", 800, 600, 400, 0, 400, 300},
+		{"height only", 800, 600, 0, 300, 400, 300},
+		{"fit by width", 800, 600, 200, 200, 200, 150},
+		{"fit by height", 600, 800, 200, 200, 150, 200},
+		{"never upscale", 100, 50, 400, 400, 100, 50},
+		{"at least 1px", 1000, 1, 10, 0, 10, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h := fitSize(tt.srcW, tt.srcH, tt.width, tt.height)
+			if w != tt.wantW || h != tt.wantH {
+				t.Errorf("fitSize(%d, %d, %d, %d) = %dx%d, want %dx%d", tt.srcW, tt.srcH, tt.width, tt.height, w, h, tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestCleanImagePath(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "a.png", want: "a.png"},
+		{in: "products/a.png", want: "products/a.png"},
+		{in: "products/./sub/../a.png", want: "products/a.png"},
+		{in: "../etc/passwd", wantErr: true},
+		{in: "products/../../a.png", wantErr: true},
+		{in: "/etc/passwd", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := cleanImagePath(tt.in)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidImagePath) {
+					t.Errorf("cleanImagePath(%q) error = %v, want ErrInvalidImagePath", tt.in, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("cleanImagePath(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+// Open の回数を数える ImageStore
+type countingImageStore struct {
+	storage.ImageStore
+	opens atomic.Int32
+}
+
+func (s *countingImageStore) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	s.opens.Add(1)
+	return s.ImageStore.Open(ctx, path)
+}
+
+func newTestImageService(t *testing.T, files map[string][]byte) (*ImageService, *countingImageStore) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := &countingImageStore{ImageStore: storage.NewFileSystemStore(dir)}
+	svc, err := NewImageService(store, t.TempDir(), 1<<20, 1<<20, []int{64, 128}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc, store
+}
+
+func encodeTestPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		img.Set(x, 0, color.NRGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// IHDR の幅と高さだけを書き換えた PNG を返す
+// DecodeConfig はヘッダーしか読まないため、巨大な画像を作らずにサイズの上限を確かめられる
+func withPNGSize(data []byte, w, h uint32) []byte {
+	data = bytes.Clone(data)
+	// シグネチャ(8) + 長さ(4) + "IHDR"(4) の後に幅と高さが続き、データ(13)の後にCRCがある
+	binary.BigEndian.PutUint32(data[16:20], w)
+	binary.BigEndian.PutUint32(data[20:24], h)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestImageServiceGetImageResizes(t *testing.T) {
+	svc, _ := newTestImageService(t, map[string][]byte{"a.png": encodeTestPNG(t, 256, 128)})
+
+	img, err := svc.GetImage(context.Background(), "a.png", ImageOptions{Width: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ContentType != "image/png" {
+		t.Errorf("ContentType = %q, want image/png", img.ContentType)
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 64 || cfg.Height != 32 {
+		t.Errorf("resized to %dx%d, want 64x32", cfg.Width, cfg.Height)
+	}
+}
+
+func TestImageServiceGetImageRejectsTooManyPixels(t *testing.T) {
+	huge := withPNGSize(encodeTestPNG(t, 1, 1), 10000, 10000)
+	svc, _ := newTestImageService(t, map[string][]byte{"huge.png": huge})
+
+	_, err := svc.GetImage(context.Background(), "huge.png", ImageOptions{Width: 64})
+	if !errors.Is(err, ErrImageTooLarge) {
+		t.Fatalf("err = %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestImageServiceGetImageCoalescesConcurrentResizes(t *testing.T) {
+	svc, store := newTestImageService(t, map[string][]byte{"a.png": encodeTestPNG(t, 512, 512)})
+
+	const n = 8
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := svc.GetImage(context.Background(), "a.png", ImageOptions{Width: 128})
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 遅れて来たリクエストはキャッシュから返るため、元画像を開くのは最初の1回だけ
+	if got := store.opens.Load(); got != 1 {
+		t.Errorf("source image opened %d times, want 1", got)
+	}
+}
@@ -5,8 +5,77 @@ import (
 	"backend/internal/repository"
 	"backend/internal/service/utils"
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"strconv"
+	"time"
 )
 
+var (
+	ErrOrderNotFound           = errors.New("order not found")
+	ErrOrderNotCancellable     = errors.New("order can no longer be cancelled")
+	ErrUnsupportedExportFormat = errors.New("unsupported export format")
+)
+
+// 発送日時を過ぎた注文を一度に shipping にする件数
+const scheduledOrderPromoteBatchSize = 500
+
+var orderExportColumns = []string{"order_id", "checkout_id", "product_id", "product_name", "value", "weight", "shipped_status", "created_at", "arrived_at"}
+
+// 注文履歴の出力1行分
+type orderExportRecord struct {
+	OrderID       int64      `json:"order_id"`
+	CheckoutID    *int64     `json:"checkout_id"`
+	ProductID     int        `json:"product_id"`
+	ProductName   string     `json:"product_name"`
+	Value         int        `json:"value"`
+	Weight        int        `json:"weight"`
+	ShippedStatus string     `json:"shipped_status"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ArrivedAt     *time.Time `json:"arrived_at"`
+}
+
+func newOrderExportRecord(o model.Order) orderExportRecord {
+	rec := orderExportRecord{
+		OrderID:       o.OrderID,
+		CheckoutID:    o.CheckoutID,
+		ProductID:     o.ProductID,
+		ProductName:   o.ProductName,
+		Value:         o.Value,
+		Weight:        o.Weight,
+		ShippedStatus: o.ShippedStatus,
+		CreatedAt:     o.CreatedAt,
+	}
+	if o.ArrivedAt.Valid {
+		rec.ArrivedAt = &o.ArrivedAt.Time
+	}
+	return rec
+}
+
+func (rec orderExportRecord) csvRow() []string {
+	row := []string{
+		strconv.FormatInt(rec.OrderID, 10),
+		"",
+		strconv.Itoa(rec.ProductID),
+		rec.ProductName,
+		strconv.Itoa(rec.Value),
+		strconv.Itoa(rec.Weight),
+		rec.ShippedStatus,
+		rec.CreatedAt.Format(time.RFC3339),
+		"",
+	}
+	if rec.CheckoutID != nil {
+		row[1] = strconv.FormatInt(*rec.CheckoutID, 10)
+	}
+	if rec.ArrivedAt != nil {
+		row[8] = rec.ArrivedAt.Format(time.RFC3339)
+	}
+	return row
+}
+
 type OrderService struct {
 	store *repository.Store
 }
@@ -32,3 +101,234 @@ func (s *OrderService) FetchOrders(ctx context.Context, userID int, req model.Li
 	}
 	return orders, total, nil
 }
+
+// ユーザーの注文履歴を注文ヘッダー単位で取得
+func (s *OrderService) FetchCheckouts(ctx context.Context, userID int, req model.ListRequest) ([]model.Checkout, int, error) {
+	var checkouts []model.Checkout
+	var total int
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		if req.PageSize <= 0 {
+			req.PageSize = 20
+		}
+		if req.Page > 0 {
+			req.Offset = (req.Page - 1) * req.PageSize
+		}
+
+		var err error
+		checkouts, total, err = s.store.CheckoutRepo.ListByUser(ctx, userID, req)
+		if err != nil {
+			return err
+		}
+
+		ids := make([]int64, len(checkouts))
+		for i, c := range checkouts {
+			ids[i] = c.CheckoutID
+		}
+		items, err := s.store.CheckoutRepo.ListItems(ctx, ids)
+		if err != nil {
+			return err
+		}
+		statusCounts, err := s.store.CheckoutRepo.CountStatuses(ctx, ids)
+		if err != nil {
+			return err
+		}
+
+		itemsByCheckout := make(map[int64][]model.CheckoutItem, len(checkouts))
+		for _, item := range items {
+			itemsByCheckout[item.CheckoutID] = append(itemsByCheckout[item.CheckoutID], item)
+		}
+		for i := range checkouts {
+			checkouts[i].Items = itemsByCheckout[checkouts[i].CheckoutID]
+			if checkouts[i].Items == nil {
+				checkouts[i].Items = []model.CheckoutItem{}
+			}
+			checkouts[i].StatusCounts = statusCounts[checkouts[i].CheckoutID]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return checkouts, total, nil
+}
+
+// 注文をキャンセルする
+// ロボットが引き受けた後の注文はキャンセルできない。キャンセル済みの注文には何もしない
+func (s *OrderService) CancelOrder(ctx context.Context, userID int, orderID int64) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		// 配送計画の作成と同時に実行されても、どちらか一方だけが注文を更新できる
+		var cancelled bool
+		err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			var err error
+			cancelled, err = txStore.OrderRepo.Cancel(ctx, userID, orderID)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if cancelled {
+			log.Printf("Order %d cancelled by user %d", orderID, userID)
+			return nil
+		}
+
+		status, err := s.store.OrderRepo.FindStatus(ctx, userID, orderID)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case "":
+			return ErrOrderNotFound
+		case "cancelled":
+			return nil
+		default:
+			return ErrOrderNotCancellable
+		}
+	})
+}
+
+// 注文の詳細を取得する
+// 商品、配送を担当するロボットと配送計画、ステータスの変更履歴を含める
+func (s *OrderService) GetOrderDetail(ctx context.Context, userID int, orderID int64) (*model.OrderDetail, error) {
+	var detail *model.OrderDetail
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		order, err := s.store.OrderRepo.FindByID(ctx, userID, orderID)
+		if err != nil {
+			return err
+		}
+		if order == nil {
+			return ErrOrderNotFound
+		}
+		detail = &model.OrderDetail{Order: *order}
+
+		products, err := s.store.ProductRepo.FindByIDs(ctx, []int{order.ProductID})
+		if err != nil {
+			return err
+		}
+		if len(products) > 0 {
+			detail.Product = products[0]
+		}
+
+		if order.DeliveryPlanID != nil {
+			detail.DeliveryPlan, err = s.store.DeliveryPlanRepo.FindByID(ctx, *order.DeliveryPlanID)
+			if err != nil {
+				return err
+			}
+		}
+
+		detail.Timeline, err = s.store.OrderRepo.ListStatusHistory(ctx, orderID)
+		if err != nil {
+			return err
+		}
+		if len(detail.Timeline) == 0 {
+			detail.Timeline = legacyTimeline(order)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+// 履歴テーブルより前に作成された注文は、作成日時と到着日時から分かる範囲で履歴を組み立てる
+func legacyTimeline(order *model.Order) []model.OrderStatusEvent {
+	timeline := []model.OrderStatusEvent{{ShippedStatus: "shipping", ChangedAt: order.CreatedAt}}
+	if order.ArrivedAt.Valid {
+		timeline = append(timeline, model.OrderStatusEvent{ShippedStatus: "completed", ChangedAt: order.ArrivedAt.Time})
+	}
+	return timeline
+}
+
+// 注文履歴を一覧と同じ条件で CSV または JSON に書き出す
+// 件数が多くてもメモリに載せないよう、カーソルで読みながら書き込む
+func (s *OrderService) ExportOrders(ctx context.Context, w io.Writer, userID int, req model.ListRequest, format string) error {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(orderExportColumns); err != nil {
+			return err
+		}
+		err := s.store.OrderRepo.ForEachOrder(ctx, userID, req, func(o model.Order) error {
+			return cw.Write(newOrderExportRecord(o).csvRow())
+		})
+		if err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		if _, err := io.WriteString(w, "["); err != nil {
+			return err
+		}
+		enc := json.NewEncoder(w)
+		first := true
+		err := s.store.OrderRepo.ForEachOrder(ctx, userID, req, func(o model.Order) error {
+			if !first {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			first = false
+			return enc.Encode(newOrderExportRecord(o))
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, "]\n")
+		return err
+	default:
+		return ErrUnsupportedExportFormat
+	}
+}
+
+// 注文日時が [from, to) の注文を集計する
+func (s *OrderService) FetchStats(ctx context.Context, userID int, from, to time.Time, interval string) (*model.OrderStats, error) {
+	stats := &model.OrderStats{From: from, To: to, Interval: interval}
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		if stats.StatusCounts, err = s.store.OrderRepo.CountByStatus(ctx, userID, from, to); err != nil {
+			return err
+		}
+		if stats.Series, err = s.store.OrderRepo.AggregateByPeriod(ctx, userID, from, to, interval); err != nil {
+			return err
+		}
+		if stats.Series == nil {
+			stats.Series = []model.OrderStatsPeriod{}
+		}
+		stats.AverageLeadTimeSeconds, err = s.store.OrderRepo.AverageLeadTime(ctx, userID, from, to)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// 発送日時を過ぎた scheduled の注文を shipping にし、配送計画の対象にする
+// 定期ジョブから呼び出す。複数のバックエンドで同時に実行しても同じ注文を重複して更新しない
+func (s *OrderService) PromoteScheduledOrders(ctx context.Context) error {
+	var total int
+	for {
+		var n int
+		err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			ids, err := txStore.OrderRepo.LockDueScheduledOrders(ctx, time.Now(), scheduledOrderPromoteBatchSize)
+			if err != nil {
+				return err
+			}
+			n = len(ids)
+			_, err = txStore.OrderRepo.UpdateStatuses(ctx, ids, []string{"scheduled"}, "shipping")
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		total += n
+		if n < scheduledOrderPromoteBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("Promoted %d scheduled orders to shipping", total)
+	}
+	return nil
+}
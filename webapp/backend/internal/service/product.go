@@ -2,56 +2,371 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"strings"
+	"time"
 
 	"backend/internal/model"
 	"backend/internal/repository"
 )
 
+// 注文1行あたりの数量の上限
+const maxOrderLineQuantity = 1000
+
+var (
+	ErrEmptyOrder       = errors.New("order has no items")
+	ErrCheckoutNotFound = errors.New("checkout not found")
+	ErrNothingToReorder = errors.New("no products left to reorder")
+	ErrInvalidShipAfter = errors.New("ship_after is too far in the future")
+)
+
+// 発送日時として指定できる最も先の日時(現在からの期間)
+const maxShipAfter = 365 * 24 * time.Hour
+
+// 注文内容に誤りがある場合のエラー
+// 誤りのある明細ごとの理由を持つ
+type OrderValidationError struct {
+	Errors []model.OrderItemError
+}
+
+func (e *OrderValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, ie := range e.Errors {
+		msgs[i] = fmt.Sprintf("items[%d].%s: %s", ie.Index, ie.Field, ie.Message)
+	}
+	return "invalid order items: " + strings.Join(msgs, "; ")
+}
+
+// DBを参照せずに確認できる明細の誤り(数量の範囲、同じ商品の重複)を返す
+func validateOrderItems(items []model.RequestItem) []model.OrderItemError {
+	var errs []model.OrderItemError
+	seen := make(map[int]int, len(items))
+	for i, item := range items {
+		if item.Quantity < 1 || item.Quantity > maxOrderLineQuantity {
+			errs = append(errs, model.OrderItemError{
+				Index:     i,
+				ProductID: item.ProductID,
+				Field:     "quantity",
+				Message:   fmt.Sprintf("quantity must be between 1 and %d", maxOrderLineQuantity),
+			})
+		}
+		if first, ok := seen[item.ProductID]; ok {
+			errs = append(errs, model.OrderItemError{
+				Index:     i,
+				ProductID: item.ProductID,
+				Field:     "product_id",
+				Message:   fmt.Sprintf("duplicate of items[%d]", first),
+			})
+		} else {
+			seen[item.ProductID] = i
+		}
+	}
+	return errs
+}
+
 type ProductService struct {
-	store *repository.Store
+	store       *repository.Store
+	cache       *ProductCache
+	idempotency *IdempotencyService
+	quota       *QuotaService
 }
 
-func NewProductService(store *repository.Store) *ProductService {
-	return &ProductService{store: store}
+func NewProductService(store *repository.Store, cache *ProductCache, idempotency *IdempotencyService, quota *QuotaService) *ProductService {
+	return &ProductService{store: store, cache: cache, idempotency: idempotency, quota: quota}
 }
 
-func (s *ProductService) CreateOrders(ctx context.Context, userID int, items []model.RequestItem) ([]string, error) {
-	var insertedOrderIDs []string
+// 注文ヘッダーと明細を作成し、数量分の注文(配送単位)を登録する
+// req.ShipAfter が未来の日時の場合、注文はその日時まで scheduled として保持する
+// idempotencyKey を指定した場合、同じキーでの再送には最初の結果を返し replayed=true とする
+func (s *ProductService) CreateOrders(ctx context.Context, userID int, req model.CreateOrderRequest, idempotencyKey string) (checkout *model.Checkout, replayed bool, err error) {
+	if req.ShipAfter != nil && req.ShipAfter.After(time.Now().Add(maxShipAfter)) {
+		return nil, false, ErrInvalidShipAfter
+	}
 
-	err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
-		// 数量0を除外
-		itemsToProcess := make([]model.Order, 0, len(items))
-		for _, item := range items {
-			for i := 0; i < item.Quantity; i++ {
-				itemsToProcess = append(itemsToProcess, model.Order{
-					UserID:    userID,
-					ProductID: item.ProductID,
-				})
-			}
+	var requestHash string
+	if idempotencyKey != "" {
+		if requestHash, err = idempotencyRequestHash(req); err != nil {
+			return nil, false, err
 		}
-		if len(itemsToProcess) == 0 {
-			return nil
+	}
+
+	err = s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		checkout = &model.Checkout{UserID: userID, Items: []model.CheckoutItem{}}
+		replayed = false
+
+		if idempotencyKey != "" {
+			// キーの予約を注文の作成と同じトランザクションで行い、同時に再送されても二重に注文しない
+			rec, err := s.idempotency.reserve(ctx, txStore, userID, idempotencyKey, requestHash)
+			if err != nil {
+				return err
+			}
+			if rec != nil {
+				replayed = true
+				return json.Unmarshal(rec.ResponseBody, checkout)
+			}
 		}
 
-		// バルクインサート
-		orderIDs, err := txStore.OrderRepo.CreateBulk(ctx, itemsToProcess)
-		if err != nil {
+		if err := s.createCheckout(ctx, txStore, checkout, req.Items, req.ShipAfter); err != nil {
 			return err
 		}
-		insertedOrderIDs = append(insertedOrderIDs, orderIDs...)
+		if idempotencyKey != "" {
+			return s.idempotency.save(ctx, txStore, userID, idempotencyKey, checkout)
+		}
 		return nil
 	})
 
+	if err != nil {
+		return nil, false, err
+	}
+
+	if replayed {
+		log.Printf("Replayed checkout %d for user %d (idempotency key %q)", checkout.CheckoutID, userID, idempotencyKey)
+	} else {
+		log.Printf("Created checkout %d (%d lines, total value %d) for user %d", checkout.CheckoutID, len(checkout.Items), checkout.TotalValue, userID)
+	}
+	return checkout, replayed, nil
+}
+
+// 過去の注文(数量1単位)と同じ商品を1つ注文する
+func (s *ProductService) ReorderOrder(ctx context.Context, userID int, orderID int64, idempotencyKey string) (*model.ReorderResult, error) {
+	order, err := s.store.OrderRepo.FindByID(ctx, userID, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order == nil {
+		return nil, ErrOrderNotFound
+	}
+	return s.reorder(ctx, userID, []model.RequestItem{{ProductID: order.ProductID, Quantity: 1}}, idempotencyKey)
+}
+
+// 過去の注文ヘッダーと同じ商品・数量で注文する
+func (s *ProductService) ReorderCheckout(ctx context.Context, userID int, checkoutID int64, idempotencyKey string) (*model.ReorderResult, error) {
+	checkout, err := s.store.CheckoutRepo.FindByID(ctx, userID, checkoutID)
+	if err != nil {
+		return nil, err
+	}
+	if checkout == nil {
+		return nil, ErrCheckoutNotFound
+	}
+	lines, err := s.store.CheckoutRepo.ListItems(ctx, []int64{checkoutID})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]model.RequestItem, len(lines))
+	for i, line := range lines {
+		items[i] = model.RequestItem{ProductID: line.ProductID, Quantity: line.Quantity}
+	}
+	return s.reorder(ctx, userID, items, idempotencyKey)
+}
+
+// 現在は存在しない商品を除いて CreateOrders で注文する
+func (s *ProductService) reorder(ctx context.Context, userID int, items []model.RequestItem, idempotencyKey string) (*model.ReorderResult, error) {
+	productIDs := make([]int, len(items))
+	for i, item := range items {
+		productIDs[i] = item.ProductID
+	}
+	products, err := s.store.ProductRepo.FindByIDs(ctx, productIDs)
 	if err != nil {
 		return nil, err
 	}
+	exists := make(map[int]bool, len(products))
+	for _, p := range products {
+		exists[p.ProductID] = true
+	}
+
+	result := &model.ReorderResult{Skipped: []model.SkippedItem{}}
+	available := make([]model.RequestItem, 0, len(items))
+	for _, item := range items {
+		if !exists[item.ProductID] {
+			result.Skipped = append(result.Skipped, model.SkippedItem{
+				ProductID: item.ProductID,
+				Quantity:  item.Quantity,
+				Reason:    "product no longer exists",
+			})
+			continue
+		}
+		available = append(available, item)
+	}
+	if len(available) == 0 {
+		return nil, ErrNothingToReorder
+	}
 
-	log.Printf("Created %d orders for user %d", len(insertedOrderIDs), userID)
-	return insertedOrderIDs, nil
+	result.Checkout, result.Replayed, err = s.CreateOrders(ctx, userID, model.CreateOrderRequest{Items: available}, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 注文ヘッダーと明細、数量分の注文を txStore 上で作成し checkout に結果を設定する
+func (s *ProductService) createCheckout(ctx context.Context, txStore *repository.Store, checkout *model.Checkout, items []model.RequestItem, shipAfter *time.Time) error {
+	userID := checkout.UserID
+	// 過去の日時が指定された場合はすぐに発送できる注文にする
+	if shipAfter != nil && !shipAfter.After(time.Now()) {
+		shipAfter = nil
+	}
+	checkout.ShipAfter = shipAfter
+
+	if len(items) == 0 {
+		return ErrEmptyOrder
+	}
+	itemErrors := validateOrderItems(items)
+
+	// 注文時点の単価を明細に残す
+	productIDs := make([]int, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	products, err := txStore.ProductRepo.FindByIDs(ctx, productIDs)
+	if err != nil {
+		return err
+	}
+	productByID := make(map[int]model.Product, len(products))
+	for _, p := range products {
+		productByID[p.ProductID] = p
+	}
+	for i, item := range items {
+		if _, ok := productByID[item.ProductID]; !ok {
+			itemErrors = append(itemErrors, model.OrderItemError{
+				Index:     i,
+				ProductID: item.ProductID,
+				Field:     "product_id",
+				Message:   "product does not exist",
+			})
+		}
+	}
+	if len(itemErrors) > 0 {
+		sort.SliceStable(itemErrors, func(i, j int) bool { return itemErrors[i].Index < itemErrors[j].Index })
+		return &OrderValidationError{Errors: itemErrors}
+	}
+
+	units := 0
+	for _, item := range items {
+		units += item.Quantity
+	}
+	if err := s.quota.check(ctx, txStore, userID, units); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		p := productByID[item.ProductID]
+		checkout.Items = append(checkout.Items, model.CheckoutItem{
+			ProductID:   p.ProductID,
+			ProductName: p.Name,
+			Quantity:    item.Quantity,
+			UnitValue:   p.Value,
+			Subtotal:    p.Value * item.Quantity,
+		})
+		checkout.TotalValue += p.Value * item.Quantity
+	}
+
+	if err := txStore.CheckoutRepo.Create(ctx, checkout); err != nil {
+		return err
+	}
+
+	// ロボットは数量1単位で配送計画を立てるため、数量分の注文を作成する
+	var itemsToProcess []model.Order
+	for _, item := range checkout.Items {
+		for i := 0; i < item.Quantity; i++ {
+			itemsToProcess = append(itemsToProcess, model.Order{
+				UserID:     userID,
+				ProductID:  item.ProductID,
+				CheckoutID: &checkout.CheckoutID,
+				ShipAfter:  shipAfter,
+			})
+		}
+	}
+
+	// バルクインサート
+	orderIDs, err := txStore.OrderRepo.CreateBulk(ctx, itemsToProcess)
+	if err != nil {
+		return err
+	}
+	for i := range checkout.Items {
+		n := checkout.Items[i].Quantity
+		checkout.Items[i].OrderIDs = orderIDs[:n]
+		orderIDs = orderIDs[n:]
+	}
+	return nil
 }
 
 func (s *ProductService) FetchProducts(ctx context.Context, userID int, req model.ListRequest) ([]model.Product, int, error) {
-	products, total, err := s.store.ProductRepo.ListProducts(ctx, userID, req)
-	return products, total, err
+	// 商品一覧はユーザーによらないため userID はキャッシュのキーに含めない
+	cached, total, err := s.cache.GetOrLoad(ctx, req, func(ctx context.Context) ([]model.Product, int, error) {
+		categories, err := s.store.CategoryRepo.ListAll(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		idx := newCategoryIndex(categories)
+
+		if req.CategoryID > 0 {
+			req.CategoryIDs = idx.descendantIDs(req.CategoryID)
+			if len(req.CategoryIDs) == 0 {
+				return []model.Product{}, 0, nil
+			}
+		}
+
+		products, total, err := s.store.ProductRepo.ListProducts(ctx, userID, req)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := s.attachBreadcrumbs(ctx, idx, products); err != nil {
+			return nil, 0, err
+		}
+		return products, total, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// お気に入りはユーザーごとに異なるため、キャッシュとは別にまとめて取得する
+	// キャッシュのスライスを書き換えないようコピーしてから設定する
+	products := make([]model.Product, len(cached))
+	copy(products, cached)
+	productIDs := make([]int, len(products))
+	for i, p := range products {
+		productIDs[i] = p.ProductID
+	}
+	favorites, err := s.store.FavoriteRepo.FindFavoriteProductIDs(ctx, userID, productIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range products {
+		products[i].IsFavorite = favorites[products[i].ProductID]
+	}
+	return products, total, nil
+}
+
+// 商品ごとに所属カテゴリのパンくずを設定する
+func (s *ProductService) attachBreadcrumbs(ctx context.Context, idx *categoryIndex, products []model.Product) error {
+	if len(products) == 0 || len(idx.byID) == 0 {
+		return nil
+	}
+	productIDs := make([]int, len(products))
+	for i, p := range products {
+		productIDs[i] = p.ProductID
+	}
+	rows, err := s.store.CategoryRepo.ListByProductIDs(ctx, productIDs)
+	if err != nil {
+		return err
+	}
+
+	crumbs := make(map[int][][]model.CategoryRef, len(products))
+	for _, row := range rows {
+		if path := idx.breadcrumb(row.CategoryID); len(path) > 0 {
+			crumbs[row.ProductID] = append(crumbs[row.ProductID], path)
+		}
+	}
+	for i := range products {
+		products[i].Breadcrumbs = crumbs[products[i].ProductID]
+	}
+	return nil
+}
+
+func (s *ProductService) CacheStats() model.ProductCacheStats {
+	return s.cache.Stats()
 }
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"backend/internal/cache"
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+
+	"golang.org/x/sync/singleflight"
+)
+
+type productListResult struct {
+	products []model.Product
+	total    int
+}
+
+// 商品一覧の読み込みキャッシュ
+// 同じ条件の同時リクエストは1回のDBアクセスにまとめる
+// 返す商品スライスはキャッシュと共有しているため、呼び出し側で変更しないこと
+type ProductCache struct {
+	lru   *cache.LRU[string, productListResult]
+	group singleflight.Group
+
+	// 商品が更新されるたびに進める。キーに含めることで更新前に始まった読み込み結果を使わない
+	generation atomic.Uint64
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	sharedLoads   atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+// ttl が 0 以下の場合は nil を返し、キャッシュを無効にする
+func NewProductCache(maxEntries int, ttl time.Duration) *ProductCache {
+	if ttl <= 0 || maxEntries <= 0 {
+		return nil
+	}
+	return &ProductCache{lru: cache.NewLRU[string, productListResult](maxEntries, ttl)}
+}
+
+func (c *ProductCache) GetOrLoad(ctx context.Context, req model.ListRequest, load func(ctx context.Context) ([]model.Product, int, error)) ([]model.Product, int, error) {
+	if c == nil {
+		return load(ctx)
+	}
+
+	key := c.key(req)
+	if res, ok := c.lru.Get(key); ok {
+		c.hits.Add(1)
+		return res.products, res.total, nil
+	}
+	c.misses.Add(1)
+
+	// 最初のリクエストがキャンセルされても、相乗りしている他のリクエストは失敗させない
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		var res productListResult
+		err := utils.WithTimeout(context.WithoutCancel(ctx), func(ctx context.Context) error {
+			var err error
+			res.products, res.total, err = load(ctx)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.lru.Set(key, res)
+		return res, nil
+	})
+
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return nil, 0, r.Err
+		}
+		if r.Shared {
+			c.sharedLoads.Add(1)
+		}
+		res := r.Val.(productListResult)
+		return res.products, res.total, nil
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+}
+
+// 商品の追加・更新時に呼び出し、キャッシュをすべて破棄する
+func (c *ProductCache) Invalidate() {
+	if c == nil {
+		return
+	}
+	c.generation.Add(1)
+	c.invalidations.Add(1)
+	c.lru.Purge()
+}
+
+func (c *ProductCache) Stats() model.ProductCacheStats {
+	if c == nil {
+		return model.ProductCacheStats{}
+	}
+	stats := model.ProductCacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		SharedLoads:   c.sharedLoads.Load(),
+		Invalidations: c.invalidations.Load(),
+		Entries:       c.lru.Len(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+func (c *ProductCache) key(req model.ListRequest) string {
+	req = repository.NormalizeProductListRequest(req)
+	return fmt.Sprintf("%d|%q|%d|%d|%s|%s|%d|%q",
+		c.generation.Load(), req.Search, req.PageSize, req.Offset, req.SortField, req.SortOrder, req.CategoryID, req.Tag)
+}
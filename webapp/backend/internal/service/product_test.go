@@ -0,0 +1,54 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"backend/internal/model"
+)
+
+func TestValidateOrderItems(t *testing.T) {
+	quantityErr := func(index, productID int) model.OrderItemError {
+		return model.OrderItemError{Index: index, ProductID: productID, Field: "quantity", Message: "quantity must be between 1 and 1000"}
+	}
+
+	tests := []struct {
+		name  string
+		items []model.RequestItem
+		want  []model.OrderItemError
+	}{
+		{name: "empty", items: nil},
+		{
+			name:  "valid",
+			items: []model.RequestItem{{ProductID: 1, Quantity: 1}, {ProductID: 2, Quantity: maxOrderLineQuantity}},
+		},
+		{
+			name:  "quantity out of range",
+			items: []model.RequestItem{{ProductID: 1, Quantity: 0}, {ProductID: 2, Quantity: -1}, {ProductID: 3, Quantity: maxOrderLineQuantity + 1}},
+			want:  []model.OrderItemError{quantityErr(0, 1), quantityErr(1, 2), quantityErr(2, 3)},
+		},
+		{
+			name:  "duplicated product refers to the first line",
+			items: []model.RequestItem{{ProductID: 1, Quantity: 1}, {ProductID: 2, Quantity: 1}, {ProductID: 1, Quantity: 2}, {ProductID: 1, Quantity: 3}},
+			want: []model.OrderItemError{
+				{Index: 2, ProductID: 1, Field: "product_id", Message: "duplicate of items[0]"},
+				{Index: 3, ProductID: 1, Field: "product_id", Message: "duplicate of items[0]"},
+			},
+		},
+		{
+			name:  "both errors on one line",
+			items: []model.RequestItem{{ProductID: 1, Quantity: 1}, {ProductID: 1, Quantity: 0}},
+			want: []model.OrderItemError{
+				quantityErr(1, 1),
+				{Index: 1, ProductID: 1, Field: "product_id", Message: "duplicate of items[0]"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateOrderItems(tt.items); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateOrderItems() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
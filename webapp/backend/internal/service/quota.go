@@ -0,0 +1,134 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+	"context"
+	"fmt"
+	"time"
+)
+
+// 注文数の上限。0 の項目は無制限
+type OrderLimits struct {
+	// 1回の注文で指定できる数量の合計
+	MaxUnitsPerRequest int
+	// 1日(0時から翌0時まで)に注文できる数量の合計
+	MaxUnitsPerDay int
+	// ロボットがまだ引き受けていない注文(発送予約中を含む)の数量の合計
+	MaxOutstandingUnits int
+}
+
+const (
+	QuotaPerRequest  = "per_request"
+	QuotaPerDay      = "per_day"
+	QuotaOutstanding = "outstanding"
+)
+
+// 注文数の上限を超えた場合のエラー
+type OrderQuotaError struct {
+	// QuotaPerRequest, QuotaPerDay, QuotaOutstanding のいずれか
+	Quota     string
+	Limit     int
+	Used      int
+	Requested int
+	// 上限が解除されるまでの目安。不明な場合は 0
+	RetryAfter time.Duration
+}
+
+func (e *OrderQuotaError) Error() string {
+	return fmt.Sprintf("order quota %s exceeded: limit %d, used %d, requested %d", e.Quota, e.Limit, e.Used, e.Requested)
+}
+
+type QuotaService struct {
+	store  *repository.Store
+	limits OrderLimits
+}
+
+func NewQuotaService(store *repository.Store, limits OrderLimits) *QuotaService {
+	return &QuotaService{store: store, limits: limits}
+}
+
+// 注文作成のトランザクション内で上限を確認する
+// 同じユーザーの注文作成が同時に行われても上限を超えないよう、ユーザーの行ロックを取ってから数える
+func (s *QuotaService) check(ctx context.Context, txStore *repository.Store, userID, units int) error {
+	if s.limits.MaxUnitsPerRequest > 0 && units > s.limits.MaxUnitsPerRequest {
+		return &OrderQuotaError{Quota: QuotaPerRequest, Limit: s.limits.MaxUnitsPerRequest, Requested: units}
+	}
+	if s.limits.MaxUnitsPerDay <= 0 && s.limits.MaxOutstandingUnits <= 0 {
+		return nil
+	}
+
+	if err := txStore.UserRepo.LockByID(ctx, userID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if s.limits.MaxUnitsPerDay > 0 {
+		used, err := txStore.OrderRepo.CountUnitsSince(ctx, userID, startOfDay(now))
+		if err != nil {
+			return err
+		}
+		if used+units > s.limits.MaxUnitsPerDay {
+			return &OrderQuotaError{
+				Quota:      QuotaPerDay,
+				Limit:      s.limits.MaxUnitsPerDay,
+				Used:       used,
+				Requested:  units,
+				RetryAfter: startOfDay(now).AddDate(0, 0, 1).Sub(now),
+			}
+		}
+	}
+	if s.limits.MaxOutstandingUnits > 0 {
+		used, err := txStore.OrderRepo.CountOutstandingUnits(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if used+units > s.limits.MaxOutstandingUnits {
+			return &OrderQuotaError{
+				Quota:     QuotaOutstanding,
+				Limit:     s.limits.MaxOutstandingUnits,
+				Used:      used,
+				Requested: units,
+			}
+		}
+	}
+	return nil
+}
+
+// ユーザーの注文数の上限と現在の利用状況を取得する
+func (s *QuotaService) GetQuota(ctx context.Context, userID int) (*model.OrderQuota, error) {
+	now := time.Now()
+	quota := &model.OrderQuota{
+		MaxUnitsPerRequest:  s.limits.MaxUnitsPerRequest,
+		MaxUnitsPerDay:      s.limits.MaxUnitsPerDay,
+		MaxOutstandingUnits: s.limits.MaxOutstandingUnits,
+		ResetsAt:            startOfDay(now).AddDate(0, 0, 1),
+	}
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		quota.UnitsToday, err = s.store.OrderRepo.CountUnitsSince(ctx, userID, startOfDay(now))
+		if err != nil {
+			return err
+		}
+		quota.OutstandingUnits, err = s.store.OrderRepo.CountOutstandingUnits(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.limits.MaxUnitsPerDay > 0 {
+		remaining := max(s.limits.MaxUnitsPerDay-quota.UnitsToday, 0)
+		quota.RemainingToday = &remaining
+	}
+	if s.limits.MaxOutstandingUnits > 0 {
+		remaining := max(s.limits.MaxOutstandingUnits-quota.OutstandingUnits, 0)
+		quota.RemainingOutstanding = &remaining
+	}
+	return quota, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+	"context"
+	"log"
+	"time"
+)
+
+const recommendationLockName = "recommendation_stats_refresh"
+
+type RecommendationService struct {
+	store *repository.Store
+	// 集計対象にする注文の期間
+	window time.Duration
+}
+
+func NewRecommendationService(store *repository.Store, window time.Duration) *RecommendationService {
+	return &RecommendationService{store: store, window: window}
+}
+
+// 注文履歴からおすすめ用の統計を再計算する
+// 定期ジョブから呼び出す。他のバックエンドが実行中の場合は何もしない
+func (s *RecommendationService) RefreshStats(ctx context.Context) error {
+	start := time.Now()
+	since := start.Add(-s.window)
+
+	acquired, err := s.store.TryLock(ctx, recommendationLockName, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			if err := txStore.RecommendationRepo.RebuildUserProductStats(ctx, since); err != nil {
+				return err
+			}
+			return txStore.RecommendationRepo.RebuildCooccurrences(ctx, since)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if acquired {
+		log.Printf("Refreshed recommendation stats in %s", time.Since(start))
+	}
+	return nil
+}
+
+// ユーザーがよく注文する商品を取得する
+func (s *RecommendationService) FrequentlyOrdered(ctx context.Context, userID, limit int) ([]model.RecommendedProduct, error) {
+	var products []model.RecommendedProduct
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		products, err = s.store.RecommendationRepo.ListFrequentlyOrdered(ctx, userID, limit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// 指定した商品と一緒に注文されることの多い商品を取得する
+func (s *RecommendationService) CoOrdered(ctx context.Context, productID, limit int) ([]model.RecommendedProduct, error) {
+	var products []model.RecommendedProduct
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		products, err = s.store.RecommendationRepo.ListCoOrdered(ctx, productID, limit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return products, nil
+}
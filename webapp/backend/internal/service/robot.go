@@ -5,9 +5,20 @@ import (
 	"backend/internal/repository"
 	"backend/internal/service/utils"
 	"context"
+	"errors"
 	"log"
+	"slices"
 )
 
+var (
+	ErrUnknownOrderStatus      = errors.New("unknown order status")
+	ErrInvalidStatusTransition = errors.New("invalid order status transition")
+)
+
+// ロボットが報告できるステータス
+// キャンセル・予約中の注文はロボットの担当ではないため、これらのステータスの注文は変更しない
+var robotStatuses = []string{"shipping", "delivering", "completed"}
+
 type RobotService struct {
 	store *repository.Store
 }
@@ -35,7 +46,22 @@ func (s *RobotService) GenerateDeliveryPlan(ctx context.Context, robotID string,
 					orderIDs[i] = order.OrderID
 				}
 
-				if err := txStore.OrderRepo.UpdateStatuses(ctx, orderIDs, "delivering"); err != nil {
+				// 計画の作成中にキャンセルされた注文は引き受けない
+				orderIDs, err = txStore.OrderRepo.LockShippingOrders(ctx, orderIDs)
+				if err != nil {
+					return err
+				}
+				plan = excludeUnclaimedOrders(plan, orderIDs)
+				if len(orderIDs) == 0 {
+					return nil
+				}
+
+				// 注文詳細から担当ロボットと計画を参照できるよう、計画を保存して紐づける
+				plan.PlanID, err = txStore.DeliveryPlanRepo.Create(ctx, &plan)
+				if err != nil {
+					return err
+				}
+				if err := txStore.OrderRepo.AssignDeliveryPlan(ctx, plan.PlanID, orderIDs); err != nil {
 					return err
 				}
 				log.Printf("Updated status to 'delivering' for %d orders", len(orderIDs))
@@ -49,12 +75,59 @@ func (s *RobotService) GenerateDeliveryPlan(ctx context.Context, robotID string,
 	return &plan, nil
 }
 
+// ロボットからの配送状況の報告で注文のステータスを更新する
+// キャンセル・予約中の注文は変更せず ErrInvalidStatusTransition を返す
+// 既に報告されたステータスと同じ場合は何もしない
 func (s *RobotService) UpdateOrderStatus(ctx context.Context, orderID int64, newStatus string) error {
+	if !slices.Contains(robotStatuses, newStatus) {
+		return ErrUnknownOrderStatus
+	}
 	return utils.WithTimeout(ctx, func(ctx context.Context) error {
-		return s.store.OrderRepo.UpdateStatuses(ctx, []int64{orderID}, newStatus)
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			updated, err := txStore.OrderRepo.UpdateStatuses(ctx, []int64{orderID}, robotStatuses, newStatus)
+			if err != nil || len(updated) > 0 {
+				return err
+			}
+			status, err := txStore.OrderRepo.FindStatusByOrderID(ctx, orderID)
+			if err != nil {
+				return err
+			}
+			switch status {
+			case "":
+				return ErrOrderNotFound
+			case newStatus:
+				return nil
+			default:
+				return ErrInvalidStatusTransition
+			}
+		})
 	})
 }
 
+// 引き受けられなかった注文を配送計画から除き、合計を計算し直す
+func excludeUnclaimedOrders(plan model.DeliveryPlan, claimedIDs []int64) model.DeliveryPlan {
+	if len(claimedIDs) == len(plan.Orders) {
+		return plan
+	}
+	claimed := make(map[int64]bool, len(claimedIDs))
+	for _, id := range claimedIDs {
+		claimed[id] = true
+	}
+	orders := make([]model.Order, 0, len(claimedIDs))
+	plan.TotalWeight, plan.TotalValue = 0, 0
+	for _, o := range plan.Orders {
+		if !claimed[o.OrderID] {
+			continue
+		}
+		orders = append(orders, o)
+		plan.TotalWeight += o.Weight
+		plan.TotalValue += o.Value
+	}
+	log.Printf("Excluded %d orders no longer in shipping status from the delivery plan", len(plan.Orders)-len(orders))
+	plan.Orders = orders
+	return plan
+}
+
 func selectOrdersForDelivery(ctx context.Context, orders []model.Order, robotID string, robotCapacity int) (model.DeliveryPlan, error) {
 	n := len(orders)
 
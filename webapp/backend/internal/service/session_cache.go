@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"backend/internal/cache"
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// セッションID → セッションの読み込みキャッシュ
+// 存在しないセッションも negativeTTL の間キャッシュする(UserID が 0 のセッション)
+// 他のレプリカで失効したセッションは、キャッシュから返す前に session_revocations を読んで取り除く
+type SessionCache struct {
+	store       *repository.Store
+	lru         *cache.LRU[string, model.Session]
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	// 失効のたびに進める。読み込み中に失効したセッションをキャッシュしないために使う
+	mu         sync.Mutex
+	generation uint64
+	// キャッシュに反映済みの失効の revocation_id
+	lastRevocationID int64
+}
+
+// ttl が 0 以下の場合はキャッシュせず、毎回DBを参照する
+func NewSessionCache(store *repository.Store, maxEntries int, ttl, negativeTTL time.Duration) *SessionCache {
+	c := &SessionCache{store: store, ttl: ttl, negativeTTL: negativeTTL}
+	if ttl > 0 && maxEntries > 0 {
+		c.lru = cache.NewLRU[string, model.Session](maxEntries, ttl)
+	}
+	return c
+}
+
+// 有効なセッションを返す
+// 存在しない・期限切れの場合は sql.ErrNoRows を返す
+// 返すセッションはキャッシュ時点のもので、有効期限は実際より短いことがある
+func (c *SessionCache) FindSession(ctx context.Context, sessionID string) (model.Session, error) {
+	if c.lru == nil {
+		return c.store.SessionRepo.FindActiveSession(ctx, sessionID)
+	}
+
+	if session, ok := c.lru.Get(sessionID); ok && session.UserID != 0 {
+		if err := c.syncRevocations(ctx); err != nil {
+			return model.Session{}, err
+		}
+	}
+	if session, ok := c.lru.Get(sessionID); ok {
+		if session.UserID == 0 {
+			return model.Session{}, sql.ErrNoRows
+		}
+		// キャッシュのTTLより先に有効期限が来ることがあるため、ここでも確認する
+		if time.Now().Before(session.ExpiresAt) {
+			return session, nil
+		}
+		c.lru.Delete(sessionID)
+		return model.Session{}, sql.ErrNoRows
+	}
+
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+
+	session, err := c.store.SessionRepo.FindActiveSession(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.mu.Lock()
+			if c.generation == generation {
+				c.lru.SetWithTTL(sessionID, model.Session{}, c.negativeTTL)
+			}
+			c.mu.Unlock()
+		}
+		return model.Session{}, err
+	}
+	c.mu.Lock()
+	if c.generation == generation {
+		c.set(session)
+	}
+	c.mu.Unlock()
+	return session, nil
+}
+
+// 有効期限を延長したセッションでキャッシュを更新する
+// 失効などでキャッシュから削除済みの場合は何もしない
+func (c *SessionCache) Update(session model.Session) {
+	if c.lru == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.lru.Get(session.SessionID); ok {
+		c.set(session)
+	}
+}
+
+// ログアウト・失効したセッションをキャッシュから削除する
+func (c *SessionCache) Invalidate(sessionIDs ...string) {
+	if c.lru == nil || len(sessionIDs) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, sessionID := range sessionIDs {
+		c.lru.Delete(sessionID)
+	}
+}
+
+// 前回以降に失効したセッション(他のレプリカで失効したものを含む)をキャッシュから削除する
+func (c *SessionCache) syncRevocations(ctx context.Context) error {
+	c.mu.Lock()
+	afterID := c.lastRevocationID
+	c.mu.Unlock()
+
+	revocations, err := c.store.SessionRepo.ListRevocationsAfter(ctx, afterID)
+	if err != nil || len(revocations) == 0 {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, r := range revocations {
+		c.lru.Delete(r.SessionID)
+		c.lastRevocationID = max(c.lastRevocationID, r.RevocationID)
+	}
+	return nil
+}
+
+// 有効期限を過ぎて残らないよう、TTLは有効期限までに収める
+// c.mu を取得した状態で呼び出す
+func (c *SessionCache) set(session model.Session) {
+	c.lru.SetWithTTL(session.SessionID, session, min(c.ttl, time.Until(session.ExpiresAt)))
+}
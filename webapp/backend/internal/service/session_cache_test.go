@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// FindActiveSession と ListRevocationsAfter の問い合わせだけに応える DBTX
+type fakeSessionDB struct {
+	repository.DBTX
+	sessions    map[string]model.Session
+	revocations []model.SessionRevocation
+	queries     int
+	// 問い合わせの途中で呼ばれる。読み込み中の失効を再現するために使う
+	onGet func()
+}
+
+func (db *fakeSessionDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	db.queries++
+	if db.onGet != nil {
+		db.onGet()
+	}
+	session, ok := db.sessions[args[0].(string)]
+	if !ok || !session.ExpiresAt.After(time.Now()) {
+		return sql.ErrNoRows
+	}
+	*dest.(*model.Session) = session
+	return nil
+}
+
+func (db *fakeSessionDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	afterID := args[0].(int64)
+	var revocations []model.SessionRevocation
+	for _, r := range db.revocations {
+		if r.RevocationID > afterID {
+			revocations = append(revocations, r)
+		}
+	}
+	*dest.(*[]model.SessionRevocation) = revocations
+	return nil
+}
+
+// 他のレプリカでのログアウトを再現する
+func (db *fakeSessionDB) revoke(sessionID string) {
+	delete(db.sessions, sessionID)
+	db.revocations = append(db.revocations, model.SessionRevocation{
+		RevocationID: int64(len(db.revocations) + 1),
+		SessionID:    sessionID,
+	})
+}
+
+func newTestSessionCache(ttl time.Duration, sessions ...model.Session) (*SessionCache, *fakeSessionDB) {
+	db := &fakeSessionDB{sessions: make(map[string]model.Session)}
+	for _, s := range sessions {
+		db.sessions[s.SessionID] = s
+	}
+	return NewSessionCache(repository.NewStore(db), 10, ttl, time.Minute), db
+}
+
+func TestSessionCacheFindSession(t *testing.T) {
+	active := model.Session{SessionID: "active", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	tests := []struct {
+		name        string
+		ttl         time.Duration
+		sessionID   string
+		wantErr     error
+		wantQueries int
+	}{
+		{name: "cached after first lookup", ttl: time.Minute, sessionID: "active", wantQueries: 1},
+		{name: "missing session is cached too", ttl: time.Minute, sessionID: "missing", wantErr: sql.ErrNoRows, wantQueries: 1},
+		{name: "disabled cache always queries", ttl: 0, sessionID: "active", wantQueries: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, db := newTestSessionCache(tt.ttl, active)
+			for i := 0; i < 2; i++ {
+				session, err := c.FindSession(context.Background(), tt.sessionID)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("FindSession() error = %v, want %v", err, tt.wantErr)
+				}
+				if err == nil && session.UserID != active.UserID {
+					t.Errorf("UserID = %d, want %d", session.UserID, active.UserID)
+				}
+			}
+			if db.queries != tt.wantQueries {
+				t.Errorf("queries = %d, want %d", db.queries, tt.wantQueries)
+			}
+		})
+	}
+}
+
+func TestSessionCacheInvalidate(t *testing.T) {
+	c, db := newTestSessionCache(time.Minute, model.Session{SessionID: "s", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)})
+	ctx := context.Background()
+
+	if _, err := c.FindSession(ctx, "s"); err != nil {
+		t.Fatal(err)
+	}
+	delete(db.sessions, "s")
+	c.Invalidate("s")
+	if _, err := c.FindSession(ctx, "s"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("FindSession() after Invalidate error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestSessionCacheRevokedOnOtherReplica(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	c, db := newTestSessionCache(time.Minute,
+		model.Session{SessionID: "s1", UserID: 1, ExpiresAt: expiresAt},
+		model.Session{SessionID: "s2", UserID: 1, ExpiresAt: expiresAt},
+	)
+	ctx := context.Background()
+
+	for _, id := range []string{"s1", "s2"} {
+		if _, err := c.FindSession(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.revoke("s1")
+	// キャッシュの TTL 内でも、他のレプリカで失効したセッションは返さない
+	if _, err := c.FindSession(ctx, "s1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("FindSession(s1) error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := c.FindSession(ctx, "s2"); err != nil {
+		t.Errorf("FindSession(s2) error = %v", err)
+	}
+}
+
+func TestSessionCacheSkipsSessionInvalidatedWhileLoading(t *testing.T) {
+	c, db := newTestSessionCache(time.Minute, model.Session{SessionID: "s", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)})
+	ctx := context.Background()
+
+	// 読み込み中に失効した場合、読み込んだセッションはキャッシュしない
+	db.onGet = func() {
+		db.onGet = nil
+		c.Invalidate("s")
+	}
+	if _, err := c.FindSession(ctx, "s"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.FindSession(ctx, "s"); err != nil {
+		t.Fatal(err)
+	}
+	if db.queries != 2 {
+		t.Errorf("queries = %d, want 2", db.queries)
+	}
+}
+
+func TestSessionCacheRespectsExpiresAt(t *testing.T) {
+	c, db := newTestSessionCache(time.Minute, model.Session{SessionID: "s", UserID: 1, ExpiresAt: time.Now().Add(20 * time.Millisecond)})
+	ctx := context.Background()
+
+	if _, err := c.FindSession(ctx, "s"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	// キャッシュのTTLが残っていても、有効期限を過ぎたセッションは返さない
+	if _, err := c.FindSession(ctx, "s"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("FindSession() after expiry error = %v, want sql.ErrNoRows", err)
+	}
+	if db.queries != 2 {
+		t.Errorf("queries = %d, want 2", db.queries)
+	}
+}
+
+func TestSessionCacheUpdate(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	c, db := newTestSessionCache(time.Minute, model.Session{SessionID: "s", UserID: 1, ExpiresAt: expiresAt})
+	ctx := context.Background()
+
+	// キャッシュにないセッションは追加しない
+	c.Update(model.Session{SessionID: "other", UserID: 2, ExpiresAt: expiresAt})
+	if _, err := c.FindSession(ctx, "other"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("FindSession(other) error = %v, want sql.ErrNoRows", err)
+	}
+
+	if _, err := c.FindSession(ctx, "s"); err != nil {
+		t.Fatal(err)
+	}
+	renewed := expiresAt.Add(time.Hour)
+	c.Update(model.Session{SessionID: "s", UserID: 1, ExpiresAt: renewed})
+	session, err := c.FindSession(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !session.ExpiresAt.Equal(renewed) {
+		t.Errorf("ExpiresAt = %v, want %v", session.ExpiresAt, renewed)
+	}
+	if db.queries != 2 {
+		t.Errorf("queries = %d, want 2", db.queries)
+	}
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+const sessionPurgeLockName = "session_purge"
+
+type SessionPurgeConfig struct {
+	// 1回の DELETE で削除する件数
+	BatchSize int
+	// バッチ間の待ち時間。ログイン処理とのロック競合を減らす
+	BatchPause time.Duration
+	// 失効の記録を残す期間。セッションキャッシュの TTL より長くする
+	RevocationRetention time.Duration
+}
+
+// 期限切れセッションの削除
+// 複数のバックエンドで動かしても、同時に削除するのは1つだけ
+type SessionPurgeService struct {
+	store  *repository.Store
+	config SessionPurgeConfig
+
+	runs        atomic.Uint64
+	skippedRuns atomic.Uint64
+	failedRuns  atomic.Uint64
+	deleted     atomic.Uint64
+
+	mu   sync.Mutex
+	last model.SessionPurgeStats
+}
+
+func NewSessionPurgeService(store *repository.Store, config SessionPurgeConfig) *SessionPurgeService {
+	config.BatchSize = max(config.BatchSize, 1)
+	return &SessionPurgeService{store: store, config: config}
+}
+
+// 期限切れセッションをバッチごとに削除する
+// 定期ジョブから呼び出す。他のバックエンドが実行中の場合は何もしない
+func (s *SessionPurgeService) PurgeExpired(ctx context.Context) error {
+	start := time.Now()
+	var total int64
+	acquired, err := s.store.TryLock(ctx, sessionPurgeLockName, func(ctx context.Context) error {
+		// 実行中に期限切れになったものは次回に回す
+		for {
+			n, err := s.store.SessionRepo.DeleteExpired(ctx, start, s.config.BatchSize)
+			total += n
+			s.deleted.Add(uint64(n))
+			if err != nil {
+				return err
+			}
+			if n < int64(s.config.BatchSize) {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(s.config.BatchPause):
+			}
+		}
+		// キャッシュの TTL を過ぎた失効は、どのバックエンドのキャッシュにも残っていない
+		if s.config.RevocationRetention <= 0 {
+			return nil
+		}
+		for {
+			n, err := s.store.SessionRepo.DeleteRevocationsBefore(ctx, start.Add(-s.config.RevocationRetention), s.config.BatchSize)
+			if err != nil {
+				return err
+			}
+			if n < int64(s.config.BatchSize) {
+				return nil
+			}
+		}
+	})
+	if err == nil && !acquired {
+		s.skippedRuns.Add(1)
+		return nil
+	}
+
+	s.runs.Add(1)
+	last := model.SessionPurgeStats{
+		LastRunAt:      &start,
+		LastDeleted:    total,
+		LastDurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		s.failedRuns.Add(1)
+		last.LastError = err.Error()
+	}
+	s.mu.Lock()
+	s.last = last
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if total > 0 {
+		log.Printf("Purged %d expired sessions in %s", total, time.Since(start))
+	}
+	return nil
+}
+
+func (s *SessionPurgeService) Stats() model.SessionPurgeStats {
+	s.mu.Lock()
+	stats := s.last
+	s.mu.Unlock()
+	stats.Runs = s.runs.Load()
+	stats.SkippedRuns = s.skippedRuns.Load()
+	stats.FailedRuns = s.failedRuns.Load()
+	stats.DeletedSessions = s.deleted.Load()
+	return stats
+}
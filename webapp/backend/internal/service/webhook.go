@@ -0,0 +1,453 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	ErrInvalidWebhookURL = errors.New("invalid webhook url")
+	ErrTooManyWebhooks   = errors.New("too many webhooks")
+	ErrWebhookNotFound   = errors.New("webhook not found")
+
+	errWebhookDestinationNotAllowed = errors.New("webhook destination is not a public address")
+)
+
+// 送信先として許可しない、グローバルユニキャスト以外の用途に予約されたアドレス
+// ループバック・プライベート・リンクローカルは netip.Addr のメソッドで判定する
+var webhookBlockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+const (
+	webhookPurgeLockName = "webhook_event_purge"
+	// ユーザーごとに登録できる Webhook の数
+	maxWebhooksPerUser = 10
+	// 送信結果に残すエラーメッセージの長さ
+	maxWebhookErrorLength = 1024
+)
+
+type WebhookConfig struct {
+	// 1回の送信のタイムアウト
+	Timeout time.Duration
+	// 送信を試みる最大回数。超えたイベントは failed にする
+	MaxAttempts int
+	// 再送までの待ち時間は BaseBackoff から失敗するたびに倍になり、MaxBackoff で頭打ちにする
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// 1回に取得するイベント数と同時に送信する数
+	BatchSize   int
+	Concurrency int
+	// ループバック・プライベートアドレスへの送信を許可する。ローカルでの動作確認用
+	AllowPrivateNetworks bool
+	// 送信済み・失敗したイベントと送信結果を残す期間。0 以下の場合は削除しない
+	Retention time.Duration
+	// 古いイベントを1回の DELETE で削除する件数
+	PurgeBatchSize int
+}
+
+type WebhookService struct {
+	store  *repository.Store
+	config WebhookConfig
+	client *http.Client
+}
+
+func NewWebhookService(store *repository.Store, config WebhookConfig) *WebhookService {
+	config.MaxAttempts = max(config.MaxAttempts, 1)
+	config.BatchSize = max(config.BatchSize, 1)
+	config.Concurrency = max(config.Concurrency, 1)
+	config.PurgeBatchSize = max(config.PurgeBatchSize, 1)
+	return &WebhookService{
+		store:  store,
+		config: config,
+		client: &http.Client{
+			Timeout:   config.Timeout,
+			Transport: newWebhookTransport(config.AllowPrivateNetworks),
+			// リダイレクト先には署名付きのイベントを送らない
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Webhook を登録する
+// 署名の鍵はこのときのみ返す
+func (s *WebhookService) Register(ctx context.Context, userID int, rawURL string) (*model.Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil || len(rawURL) > 2048 {
+		return nil, ErrInvalidWebhookURL
+	}
+	// 送信時にも接続先を確認するが、明らかに内部を指すURLは登録時に弾く
+	if !s.config.AllowPrivateNetworks {
+		if err := checkWebhookHost(ctx, u.Hostname()); err != nil {
+			return nil, ErrInvalidWebhookURL
+		}
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	webhook := &model.Webhook{
+		UserID:    userID,
+		URL:       u.String(),
+		Secret:    secret,
+		CreatedAt: time.Now().Truncate(time.Second),
+	}
+
+	err = utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			// 同時に登録されても上限を超えないよう、ユーザーの行ロックを取ってから数える
+			if err := txStore.UserRepo.LockByID(ctx, userID); err != nil {
+				return err
+			}
+			n, err := txStore.WebhookRepo.CountByUser(ctx, userID)
+			if err != nil {
+				return err
+			}
+			if n >= maxWebhooksPerUser {
+				return ErrTooManyWebhooks
+			}
+			return txStore.WebhookRepo.Create(ctx, webhook)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (s *WebhookService) List(ctx context.Context, userID int) ([]model.Webhook, error) {
+	var webhooks []model.Webhook
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		webhooks, err = s.store.WebhookRepo.ListByUser(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (s *WebhookService) Delete(ctx context.Context, userID int, webhookID int64) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		deleted, err := s.store.WebhookRepo.Delete(ctx, userID, webhookID)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return ErrWebhookNotFound
+		}
+		return nil
+	})
+}
+
+// Webhook の送信結果を新しい順に取得する
+func (s *WebhookService) ListDeliveries(ctx context.Context, userID int, webhookID int64, limit int) ([]model.WebhookDelivery, error) {
+	var deliveries []model.WebhookDelivery
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		webhooks, err := s.store.WebhookRepo.ListByUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, w := range webhooks {
+			if w.WebhookID == webhookID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrWebhookNotFound
+		}
+		deliveries, err = s.store.WebhookRepo.ListDeliveries(ctx, userID, webhookID, limit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// 送信時刻を過ぎたイベントを送信する
+// 定期ジョブから呼び出す。複数のバックエンドで同時に実行しても同じイベントを重複して送らない
+func (s *WebhookService) Dispatch(ctx context.Context) error {
+	// 取得したイベントを送り終えるまで、他の送信処理に取得されないようにしておく時間
+	rounds := (s.config.BatchSize + s.config.Concurrency - 1) / s.config.Concurrency
+	lease := time.Duration(rounds+1) * s.config.Timeout
+
+	for {
+		var events []model.WebhookEvent
+		err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			var err error
+			events, err = txStore.WebhookRepo.ClaimDueEvents(ctx, time.Now(), s.config.BatchSize, lease)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		// 1件の記録に失敗しても、同じバッチの他のイベントの送信は続ける
+		// 記録できなかったイベントは lease 経過後に再送される
+		var g errgroup.Group
+		g.SetLimit(s.config.Concurrency)
+		for _, event := range events {
+			g.Go(func() error {
+				if err := s.deliver(ctx, event); err != nil {
+					log.Printf("Failed to record webhook event %d: %v", event.EventID, err)
+				}
+				return nil
+			})
+		}
+		g.Wait()
+		if len(events) < s.config.BatchSize {
+			return nil
+		}
+	}
+}
+
+// イベントを1回送信し、結果を記録する
+// 送信先のエラーは再送で扱うため、記録に失敗した場合のみエラーを返す
+// 送信中に Webhook が削除された場合はイベントを捨て、エラーにしない
+func (s *WebhookService) deliver(ctx context.Context, event model.WebhookEvent) error {
+	attempt := event.Attempts + 1
+	start := time.Now()
+	statusCode, sendErr := s.send(ctx, event)
+	delivery := &model.WebhookDelivery{
+		EventID:    event.EventID,
+		WebhookID:  event.WebhookID,
+		Attempt:    attempt,
+		DurationMS: time.Since(start).Milliseconds(),
+		CreatedAt:  start,
+	}
+	if statusCode > 0 {
+		delivery.StatusCode = &statusCode
+	}
+	if sendErr == nil && (statusCode < 200 || statusCode >= 300) {
+		sendErr = fmt.Errorf("unexpected status code %d", statusCode)
+	}
+	if sendErr != nil {
+		msg := sendErr.Error()
+		if len(msg) > maxWebhookErrorLength {
+			msg = msg[:maxWebhookErrorLength]
+		}
+		delivery.Error = &msg
+	}
+
+	// 停止処理中でも結果は記録する
+	ctx = context.WithoutCancel(ctx)
+	return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		// 送信中に Webhook が削除された場合、イベントも削除されているため記録せずに捨てる
+		exists, err := txStore.WebhookRepo.LockWebhook(ctx, event.WebhookID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			log.Printf("Dropping webhook event %d: webhook %d was deleted", event.EventID, event.WebhookID)
+			return nil
+		}
+		if err := txStore.WebhookRepo.CreateDelivery(ctx, delivery); err != nil {
+			return err
+		}
+		switch {
+		case sendErr == nil:
+			return txStore.WebhookRepo.MarkDelivered(ctx, event.EventID, attempt, time.Now())
+		case attempt >= s.config.MaxAttempts:
+			log.Printf("Giving up webhook event %d to webhook %d after %d attempts: %v", event.EventID, event.WebhookID, attempt, sendErr)
+			return txStore.WebhookRepo.MarkFailed(ctx, event.EventID, attempt)
+		default:
+			return txStore.WebhookRepo.ScheduleRetry(ctx, event.EventID, attempt, time.Now().Add(s.backoff(attempt)))
+		}
+	})
+}
+
+// 保存期間を過ぎた送信済み・失敗したイベントを、送信結果とともにバッチごとに削除する
+// 定期ジョブから呼び出す。他のバックエンドが実行中の場合は何もしない
+func (s *WebhookService) PurgeFinished(ctx context.Context) error {
+	if s.config.Retention <= 0 {
+		return nil
+	}
+	before := time.Now().Add(-s.config.Retention)
+	var total int64
+	_, err := s.store.TryLock(ctx, webhookPurgeLockName, func(ctx context.Context) error {
+		for {
+			n, err := s.store.WebhookRepo.DeleteFinishedEvents(ctx, before, s.config.PurgeBatchSize)
+			total += n
+			if err != nil {
+				return err
+			}
+			if n < int64(s.config.PurgeBatchSize) {
+				return nil
+			}
+		}
+	})
+	if total > 0 {
+		log.Printf("Purged %d finished webhook events", total)
+	}
+	return err
+}
+
+type webhookPayload struct {
+	ID        int64              `json:"id"`
+	Type      string             `json:"type"`
+	CreatedAt time.Time          `json:"created_at"`
+	Data      webhookPayloadData `json:"data"`
+}
+
+type webhookPayloadData struct {
+	OrderID       int64  `json:"order_id"`
+	ShippedStatus string `json:"shipped_status"`
+}
+
+// イベントを POST し、レスポンスのステータスコードを返す
+// 受信側は X-Webhook-Signature の v1 が HMAC-SHA256(secret, "<t>.<body>") と一致することで送信元を確認できる
+func (s *WebhookService) send(ctx context.Context, event model.WebhookEvent) (int, error) {
+	body, err := json.Marshal(webhookPayload{
+		ID:        event.EventID,
+		Type:      event.EventType,
+		CreatedAt: event.OccurredAt,
+		Data: webhookPayloadData{
+			OrderID:       event.OrderID,
+			ShippedStatus: event.ShippedStatus,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, event.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event-Id", strconv.FormatInt(event.EventID, 10))
+	req.Header.Set("X-Webhook-Event-Type", event.EventType)
+	req.Header.Set("X-Webhook-Signature", "t="+timestamp+",v1="+signWebhook(event.Secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// コネクションを再利用できるよう、ある程度までは読み捨てる
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
+
+// attempt 回失敗した後、次に送信するまでの待ち時間
+// 同じ時刻に失敗したイベントが一斉に再送されないよう、最大 20% ずらす
+func (s *WebhookService) backoff(attempt int) time.Duration {
+	d := s.config.BaseBackoff
+	for i := 1; i < attempt && d < s.config.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, s.config.MaxBackoff)
+	return d + time.Duration(rand.Int64N(int64(d)/5+1))
+}
+
+// 接続先のアドレスを接続の直前に確認する Transport
+// 登録後に DNS の応答が内部のアドレスに変わった場合も送信しない
+// 環境変数のプロキシを経由すると接続先を確認できないため、プロキシは使わない
+func newWebhookTransport(allowPrivateNetworks bool) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if !allowPrivateNetworks {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicWebhookAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", errWebhookDestinationNotAllowed, address)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// ホスト名が公開されたアドレスのみを指しているか確認する
+func checkWebhookHost(ctx context.Context, host string) error {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !isPublicWebhookAddr(addr) {
+			return errWebhookDestinationNotAllowed
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !isPublicWebhookAddr(addr) {
+			return errWebhookDestinationNotAllowed
+		}
+	}
+	return nil
+}
+
+// Webhook の送信先として許可するアドレスか
+func isPublicWebhookAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, prefix := range webhookBlockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := crand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
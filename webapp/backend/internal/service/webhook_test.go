@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func TestWebhookBackoff(t *testing.T) {
+	svc := NewWebhookService(nil, WebhookConfig{BaseBackoff: time.Second, MaxBackoff: 10 * time.Second})
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{30, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			// 最大 20% のずれを加える
+			got := svc.backoff(tt.attempt)
+			if got < tt.want || got > tt.want+tt.want/5 {
+				t.Errorf("backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.want, tt.want+tt.want/5)
+			}
+		}
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	got := signWebhook("secret", "1700000000", []byte(`{"id":1}`))
+	if want := "3dd1b9aef568d75f6790a84bd2e5dfa1f44409eef3cbdbd3f10b837376100c11"; got != want {
+		t.Errorf("signWebhook() = %s, want %s", got, want)
+	}
+	if signWebhook("secret", "1700000001", []byte(`{"id":1}`)) == got {
+		t.Error("signature should depend on the timestamp")
+	}
+}
+
+func TestIsPublicWebhookAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.0.0.1", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:93.184.216.34", true},
+		{"64:ff9b::7f00:1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := isPublicWebhookAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("isPublicWebhookAddr(%s) = %t, want %t", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckWebhookHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		wantErr bool
+	}{
+		{"93.184.216.34", false},
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"localhost", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			err := checkWebhookHost(context.Background(), tt.host)
+			if tt.wantErr != errors.Is(err, errWebhookDestinationNotAllowed) {
+				t.Errorf("checkWebhookHost(%q) = %v, wantErr %t", tt.host, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookSendDestination(t *testing.T) {
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Webhook-Signature")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	event := model.WebhookEvent{EventID: 1, URL: srv.URL, Secret: "secret", EventType: "order.status_changed"}
+
+	// 名前解決後のアドレスも接続の直前に確認するため、ループバックには送らない
+	svc := NewWebhookService(nil, WebhookConfig{Timeout: 5 * time.Second})
+	if _, err := svc.send(context.Background(), event); !errors.Is(err, errWebhookDestinationNotAllowed) {
+		t.Errorf("send to loopback error = %v, want errWebhookDestinationNotAllowed", err)
+	}
+	if signature != "" {
+		t.Error("request should not reach the server")
+	}
+
+	svc = NewWebhookService(nil, WebhookConfig{Timeout: 5 * time.Second, AllowPrivateNetworks: true})
+	status, err := svc.send(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", status, http.StatusNoContent)
+	}
+	if !strings.HasPrefix(signature, "t=") || !strings.Contains(signature, ",v1=") {
+		t.Errorf("X-Webhook-Signature = %q", signature)
+	}
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ローカルディレクトリに保存された画像を扱う
+type FileSystemStore struct {
+	baseDir string
+}
+
+func NewFileSystemStore(baseDir string) *FileSystemStore {
+	return &FileSystemStore{baseDir: baseDir}
+}
+
+func (s *FileSystemStore) Stat(ctx context.Context, path string) (ObjectInfo, error) {
+	info, err := os.Stat(s.fullPath(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ObjectInfo{}, ErrNotExist
+		}
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *FileSystemStore) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	f, err := os.Open(s.fullPath(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotExist
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *FileSystemStore) fullPath(path string) string {
+	return filepath.Join(s.baseDir, filepath.FromSlash(path))
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotExist = errors.New("object does not exist")
+	// 署名付きURLを発行する設定がない
+	ErrSignedURLNotSupported = errors.New("signed url is not supported")
+)
+
+type ObjectInfo struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// 商品画像の保存先
+// path は "/" 区切りの相対パスで、呼び出し側で検証済みであること
+type ImageStore interface {
+	Stat(ctx context.Context, path string) (ObjectInfo, error)
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+}
+
+// 署名付きURLを発行できる保存先
+// 対応している場合はバックエンドを経由せずに画像を配信できる
+// 設定により発行できない場合は ErrSignedURLNotSupported を返す
+type URLSigner interface {
+	SignedURL(ctx context.Context, path string, expiry time.Duration) (string, error)
+}
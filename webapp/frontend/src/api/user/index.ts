@@ -17,3 +17,8 @@ export async function login(
   console.log("--- ユーザー情報の取得に成功:", user);
   return user;
 }
+
+// サーバー側のセッションを削除する
+export async function logout(): Promise<void> {
+  await axios.post("/api/logout");
+}
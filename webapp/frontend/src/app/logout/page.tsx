@@ -3,6 +3,7 @@
 import { useState, useEffect } from "react";
 import Link from "next/link";
 import { clearCookieAction } from "@/actions/auth";
+import { logout } from "@/api/user";
 
 export default function LogoutPage() {
   const [isLoggedOut, setIsLoggedOut] = useState(false);
@@ -11,6 +12,12 @@ export default function LogoutPage() {
   // このページが最初にクライアントで表示されたときに、一度だけ実行
   useEffect(() => {
     const performLogout = async () => {
+      try {
+        await logout();
+      } catch (e) {
+        // サーバー側で削除できなくてもCookieは消す
+        console.error("Logout error:", e);
+      }
       const result = await clearCookieAction();
       if (result.success) {
         setIsLoggedOut(true);
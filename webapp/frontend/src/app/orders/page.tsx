@@ -23,7 +23,12 @@ import {
 } from "@mui/material";
 import { useRouter } from "next/navigation";
 
-type ShippedStatus = "completed" | "delivering" | "shipping";
+type ShippedStatus =
+  | "completed"
+  | "delivering"
+  | "shipping"
+  | "scheduled"
+  | "cancelled";
 
 type OrdersRow = {
   id: number;
@@ -192,6 +197,10 @@ export default function OrdersPage() {
         return <Chip label="配送中" color="primary" size="small" />;
       case "shipping":
         return <Chip label="出荷準備" color="default" size="small" />;
+      case "scheduled":
+        return <Chip label="発送予約" color="info" size="small" />;
+      case "cancelled":
+        return <Chip label="キャンセル" color="error" size="small" />;
       default:
         return <Chip label="不明" color="default" size="small" />;
     }
@@ -145,7 +145,8 @@ export default function ProductListPage() {
 
   const getImageUrl = (imagePath: string) => {
     if (!imagePath) return "/default-product.png";
-    return `/api/v1/image?path=${encodeURIComponent(imagePath)}`;
+    // 一覧では 60px 表示なので高解像度ディスプレイ向けに 128px のサムネイルを取得する
+    return `/api/v1/image?path=${encodeURIComponent(imagePath)}&w=128&h=128&format=jpeg`;
   };
 
   const columns: GridColDef[] = [
@@ -0,0 +1,45 @@
+-- 注文ステータス変更の Webhook 通知
+-- 送信待ちのイベントは webhook_events に保存し、バックエンドの再起動後も送信を続ける
+
+CREATE TABLE webhooks (
+    webhook_id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id INT UNSIGNED NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    -- 署名(HMAC-SHA256)の鍵
+    secret VARCHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL,
+    INDEX idx_webhooks_user (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- 送信するイベント(outbox)
+-- status: pending(送信待ち・再送待ち) / delivered(送信済み) / failed(再送の上限に達した)
+CREATE TABLE webhook_events (
+    event_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    webhook_id INT UNSIGNED NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    order_id INT UNSIGNED NOT NULL,
+    shipped_status VARCHAR(50) NOT NULL,
+    occurred_at DATETIME NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INT UNSIGNED NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    delivered_at DATETIME NULL,
+    INDEX idx_webhook_events_due (status, next_attempt_at),
+    FOREIGN KEY (webhook_id) REFERENCES webhooks(webhook_id) ON DELETE CASCADE
+);
+
+-- 送信の試行ごとの結果
+CREATE TABLE webhook_deliveries (
+    delivery_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    event_id BIGINT UNSIGNED NOT NULL,
+    webhook_id INT UNSIGNED NOT NULL,
+    attempt INT UNSIGNED NOT NULL,
+    status_code INT NULL,
+    error VARCHAR(1024) NULL,
+    duration_ms INT UNSIGNED NOT NULL,
+    created_at DATETIME NOT NULL,
+    INDEX idx_webhook_deliveries_webhook (webhook_id, delivery_id),
+    FOREIGN KEY (event_id) REFERENCES webhook_events(event_id) ON DELETE CASCADE,
+    FOREIGN KEY (webhook_id) REFERENCES webhooks(webhook_id) ON DELETE CASCADE
+);
@@ -0,0 +1,6 @@
+-- 発送日時を指定した注文
+-- ship_after までは shipped_status = 'scheduled' で保持し、配送計画の対象にしない
+
+ALTER TABLE orders
+    ADD COLUMN ship_after DATETIME NULL,
+    ADD INDEX idx_orders_status_ship_after (shipped_status, ship_after);
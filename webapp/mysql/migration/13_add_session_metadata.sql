@@ -0,0 +1,8 @@
+-- セッション一覧に表示するための作成日時・User-Agent・IPアドレス
+-- 既存のセッションの作成日時はマイグレーション実行時刻になる
+
+ALTER TABLE user_sessions
+    ADD COLUMN created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ADD COLUMN user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    ADD COLUMN ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    ADD INDEX idx_user_sessions_user_expires (user_id, expires_at);
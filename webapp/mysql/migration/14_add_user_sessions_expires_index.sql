@@ -0,0 +1,2 @@
+-- 期限切れセッションを期限順に少しずつ削除するためのインデックス
+CREATE INDEX idx_user_sessions_expires ON user_sessions(expires_at);
@@ -0,0 +1,10 @@
+-- 配送完了時に arrived_at を記録していなかった注文に、ステータス履歴の完了日時を入れる
+UPDATE orders o
+JOIN (
+    SELECT order_id, MIN(changed_at) AS completed_at
+    FROM order_status_history
+    WHERE shipped_status = 'completed'
+    GROUP BY order_id
+) h ON h.order_id = o.order_id
+SET o.arrived_at = h.completed_at
+WHERE o.shipped_status = 'completed' AND o.arrived_at IS NULL;
@@ -0,0 +1,20 @@
+-- 注文時点の単価を注文ごとに JOIN で引けるよう、注文ヘッダー内の商品を一意にする
+-- 重複チェック導入前の明細は、先頭の行に数量をまとめてから残りを削除する
+UPDATE checkout_items ci
+JOIN (
+    SELECT MIN(checkout_item_id) AS checkout_item_id, SUM(quantity) AS quantity
+    FROM checkout_items
+    GROUP BY checkout_id, product_id
+    HAVING COUNT(*) > 1
+) d ON d.checkout_item_id = ci.checkout_item_id
+SET ci.quantity = d.quantity;
+
+DELETE ci FROM checkout_items ci
+JOIN checkout_items first
+    ON first.checkout_id = ci.checkout_id
+    AND first.product_id = ci.product_id
+    AND first.checkout_item_id < ci.checkout_item_id;
+
+ALTER TABLE checkout_items
+    ADD UNIQUE KEY idx_checkout_items_checkout_product (checkout_id, product_id),
+    DROP INDEX idx_checkout_items_checkout;
@@ -0,0 +1,2 @@
+-- 保存期間を過ぎた送信済み・失敗したイベントを少しずつ削除するためのインデックス
+CREATE INDEX idx_webhook_events_status_occurred ON webhook_events(status, occurred_at);
@@ -0,0 +1,16 @@
+-- 失効させたセッションの記録
+-- 各バックエンドはセッションキャッシュから返す前に、この表で他のバックエンドが失効させたセッションを確認する
+CREATE TABLE session_revocations (
+    revocation_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    session_uuid VARCHAR(36) NOT NULL,
+    revoked_at DATETIME NOT NULL,
+    INDEX idx_session_revocations_revoked (revoked_at)
+);
+
+-- 失効の記録を直列化するための1行だけの表
+-- 記録する前にこの行をロックし、revocation_id の順にコミットされるようにする
+CREATE TABLE session_revocation_counter (
+    id TINYINT UNSIGNED PRIMARY KEY,
+    revocations BIGINT UNSIGNED NOT NULL
+);
+INSERT INTO session_revocation_counter (id, revocations) VALUES (1, 0);
@@ -0,0 +1,12 @@
+-- ユーザーごとのお気に入り商品
+
+CREATE TABLE user_favorites (
+    user_id INT UNSIGNED NOT NULL,
+    product_id INT UNSIGNED NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (user_id, product_id),
+    -- お気に入り一覧を新しい順に取得するためのインデックス
+    INDEX idx_user_favorites_user_created (user_id, created_at),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
+);
@@ -0,0 +1,28 @@
+-- 注文履歴から集計したおすすめ用の統計
+-- バックエンドの定期ジョブが再計算して洗い替える
+
+-- ユーザーごとの商品の注文回数
+CREATE TABLE user_product_stats (
+    user_id INT UNSIGNED NOT NULL,
+    product_id INT UNSIGNED NOT NULL,
+    order_count INT UNSIGNED NOT NULL,
+    last_ordered_at DATETIME NOT NULL,
+    PRIMARY KEY (user_id, product_id),
+    INDEX idx_user_product_stats_rank (user_id, order_count, last_ordered_at),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
+);
+
+-- 同じ注文で一緒に買われた商品の組み合わせ回数
+CREATE TABLE product_cooccurrences (
+    product_id INT UNSIGNED NOT NULL,
+    related_product_id INT UNSIGNED NOT NULL,
+    co_count INT UNSIGNED NOT NULL,
+    PRIMARY KEY (product_id, related_product_id),
+    INDEX idx_product_cooccurrences_rank (product_id, co_count),
+    FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE,
+    FOREIGN KEY (related_product_id) REFERENCES products(product_id) ON DELETE CASCADE
+);
+
+-- 集計時にユーザー・注文日時ごとの商品をまとめて読むためのインデックス
+CREATE INDEX idx_orders_user_created_product ON orders(user_id, created_at, product_id);
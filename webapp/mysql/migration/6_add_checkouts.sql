@@ -0,0 +1,28 @@
+-- 注文ヘッダー(1回の注文操作)と明細
+-- ロボットは引き続き数量1単位の orders を配送計画に使うため、orders は checkout_id で紐づける
+
+CREATE TABLE checkouts (
+    checkout_id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id INT UNSIGNED NOT NULL,
+    total_value INT UNSIGNED NOT NULL,
+    created_at DATETIME NOT NULL,
+    INDEX idx_checkouts_user_created (user_id, created_at),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE checkout_items (
+    checkout_item_id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    checkout_id INT UNSIGNED NOT NULL,
+    product_id INT UNSIGNED NOT NULL,
+    quantity INT UNSIGNED NOT NULL,
+    -- 注文時点の単価
+    unit_value INT UNSIGNED NOT NULL,
+    INDEX idx_checkout_items_checkout (checkout_id),
+    FOREIGN KEY (checkout_id) REFERENCES checkouts(checkout_id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
+);
+
+ALTER TABLE orders
+    ADD COLUMN checkout_id INT UNSIGNED NULL,
+    ADD INDEX idx_orders_checkout (checkout_id),
+    ADD FOREIGN KEY (checkout_id) REFERENCES checkouts(checkout_id) ON DELETE SET NULL;
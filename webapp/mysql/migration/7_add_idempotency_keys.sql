@@ -0,0 +1,15 @@
+-- 注文作成APIの冪等キー
+-- 同じキーでの再送には保存済みのレスポンスを返す
+
+CREATE TABLE idempotency_keys (
+    user_id INT UNSIGNED NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    response_body MEDIUMBLOB NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    PRIMARY KEY (user_id, idempotency_key),
+    -- 期限切れのキーを削除するためのインデックス
+    INDEX idx_idempotency_keys_expires (expires_at),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
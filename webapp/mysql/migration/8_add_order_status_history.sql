@@ -0,0 +1,25 @@
+-- 注文のステータス変更履歴と、注文を引き受けた配送計画
+
+CREATE TABLE delivery_plans (
+    delivery_plan_id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    robot_id VARCHAR(255) NOT NULL,
+    total_weight INT UNSIGNED NOT NULL,
+    total_value INT UNSIGNED NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+ALTER TABLE orders
+    ADD COLUMN delivery_plan_id INT UNSIGNED NULL,
+    ADD INDEX idx_orders_delivery_plan (delivery_plan_id),
+    ADD FOREIGN KEY (delivery_plan_id) REFERENCES delivery_plans(delivery_plan_id) ON DELETE SET NULL;
+
+-- OrderRepository でステータスを変更するたびに1行追加する
+-- このテーブルより前に作成された注文は履歴を持たない
+CREATE TABLE order_status_history (
+    order_status_history_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    order_id INT UNSIGNED NOT NULL,
+    shipped_status VARCHAR(50) NOT NULL,
+    changed_at DATETIME NOT NULL,
+    INDEX idx_order_status_history_order (order_id, changed_at),
+    FOREIGN KEY (order_id) REFERENCES orders(order_id) ON DELETE CASCADE
+);
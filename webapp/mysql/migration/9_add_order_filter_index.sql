@@ -0,0 +1,2 @@
+-- 注文履歴をステータスと注文日時で絞り込むためのインデックス
+CREATE INDEX idx_orders_user_status_created ON orders(user_id, shipped_status, created_at);